@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// writeFileAtomic replaces path with data using the temp-file + rename pattern,
+// so concurrent readers (possibly on other NFS clients) see either the old or the
+// new contents, never a truncated file. with fsync set, the temp file is flushed
+// before the rename and the parent directory after it, making the replace durable.
+func writeFileAtomic(path string, data []byte, perm os.FileMode, fsync bool) error {
+	dir := filepath.Dir(path)
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return fmt.Errorf("create temp: %w", err)
+	}
+	tmpName := tmp.Name()
+	cleanup := func() {
+		tmp.Close()
+		os.Remove(tmpName)
+	}
+
+	if _, err := tmp.Write(data); err != nil {
+		cleanup()
+		return fmt.Errorf("write temp: %w", err)
+	}
+	if err := tmp.Chmod(perm); err != nil {
+		cleanup()
+		return fmt.Errorf("chmod temp: %w", err)
+	}
+	if fsync {
+		if err := tmp.Sync(); err != nil {
+			cleanup()
+			return fmt.Errorf("fsync temp: %w", err)
+		}
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmpName)
+		return fmt.Errorf("close temp: %w", err)
+	}
+	if err := os.Rename(tmpName, path); err != nil {
+		os.Remove(tmpName)
+		return fmt.Errorf("rename temp: %w", err)
+	}
+	if fsync {
+		if err := syncDir(dir); err != nil {
+			return fmt.Errorf("fsync dir: %w", err)
+		}
+	}
+	return nil
+}
+
+// syncDir fsyncs a directory so a preceding create/rename in it is durable.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
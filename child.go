@@ -0,0 +1,75 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"sync"
+)
+
+// childEnv names the env var that turns a re-exec of this binary into a helper
+// process for multi-process ops, instead of starting the server or running tests.
+const childEnv = "NFS_TESTER_CHILD"
+
+// childRoles maps a role name to the helper it runs; args are the child's argv[1:].
+var childRoles = map[string]func(args []string) error{
+	"append":  childAppend,
+	"writeat": childWriteAt,
+	"mmap":    childMmap,
+}
+
+// runChildIfRequested executes the requested child role and exits.
+// it returns immediately when the process was not started as a child.
+func runChildIfRequested() {
+	role := os.Getenv(childEnv)
+	if role == "" {
+		return
+	}
+	fn, ok := childRoles[role]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "unknown child role %q\n", role)
+		os.Exit(2)
+	}
+	if err := fn(os.Args[1:]); err != nil {
+		fmt.Fprintf(os.Stderr, "child %s: %v\n", role, err)
+		os.Exit(1)
+	}
+	os.Exit(0)
+}
+
+// runChildren starts one child per argument set, all in the given role, and waits for them.
+// children start together so their I/O overlaps with whatever the caller does meanwhile.
+func runChildren(role string, argSets [][]string) error {
+	exe, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("find executable: %w", err)
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, len(argSets))
+	for i, args := range argSets {
+		cmd := exec.Command(exe, args...)
+		cmd.Env = append(os.Environ(), childEnv+"="+role)
+		var stderr bytes.Buffer
+		cmd.Stderr = &stderr
+		if err := cmd.Start(); err != nil {
+			errs <- fmt.Errorf("start child %d: %w", i, err)
+			continue
+		}
+		wg.Add(1)
+		go func(idx int) {
+			defer wg.Done()
+			if err := cmd.Wait(); err != nil {
+				errs <- fmt.Errorf("child %d: %v: %s", idx, err, bytes.TrimSpace(stderr.Bytes()))
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		return err
+	}
+	return nil
+}
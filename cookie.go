@@ -0,0 +1,345 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+var (
+	cookieKeysPath    = getEnv("COOKIE_KEYS_PATH", "/data/keys/cookie-keys.json")
+	cookieKeysRefresh = getEnvDuration("COOKIE_KEYS_REFRESH", 10*time.Second)
+	cookieKeysMax     = getEnvInt("COOKIE_KEYS_MAX", 3)
+	cookieSecure      = getEnv("COOKIE_SECURE", "true") == "true"
+)
+
+const sessionCookie = "session"
+
+var (
+	ErrNoCookie  = errors.New("no session cookie")
+	ErrBadCookie = errors.New("invalid session cookie")
+)
+
+type cookieKey struct {
+	ID        string `json:"id"`
+	Secret    []byte `json:"secret"`
+	CreatedAt string `json:"created_at"`
+	CreatedBy string `json:"created_by"`
+}
+
+// cookieKeyFile is the keyring as stored on the mount, newest key first.
+type cookieKeyFile struct {
+	Signing string      `json:"signing"`
+	Keys    []cookieKey `json:"keys"`
+}
+
+// CookieKeyring signs session cookies with a key shared by every instance
+// through a file on the mount. rotation prepends a new signing key and keeps the
+// previous ones for verification, so cookies issued before a rotation stay valid
+// until their key falls off the end of the ring.
+//
+// other instances pick up a rotation when they next re-stat the file, or at once
+// when they see a cookie signed with a key ID they don't know yet.
+type CookieKeyring struct {
+	path    string
+	refresh time.Duration
+	max     int
+
+	mu        sync.Mutex
+	keys      cookieKeyFile
+	stamp     string // inode/size/mtime of the file last loaded
+	checkedAt time.Time
+	loadedAt  time.Time
+}
+
+func NewCookieKeyring(path string, refresh time.Duration, max int) (*CookieKeyring, error) {
+	if max < 1 {
+		max = 1
+	}
+	k := &CookieKeyring{path: path, refresh: refresh, max: max}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, fmt.Errorf("create key dir: %w", err)
+	}
+
+	// the first instance to start creates the ring; the rest load it
+	unlock, _, err := lockPath(path+".lock", true)
+	if err != nil {
+		return nil, fmt.Errorf("lock cookie keys: %w", err)
+	}
+	defer unlock()
+	if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+		if _, err := k.rotateLocked(hostname); err != nil {
+			return nil, err
+		}
+	}
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if err := k.load(); err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+// Sign returns the cookie value for a session ID: <id>.<key id>.<mac>.
+func (k *CookieKeyring) Sign(sessionID string) (string, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.maybeReload(false)
+	key := k.find(k.keys.Signing)
+	if key == nil {
+		return "", fmt.Errorf("signing key %q missing from keyring", k.keys.Signing)
+	}
+	return sessionID + "." + key.ID + "." + cookieMAC(key, sessionID), nil
+}
+
+// Verify checks a cookie value and returns the session ID it carries.
+func (k *CookieKeyring) Verify(value string) (string, error) {
+	parts := strings.Split(value, ".")
+	if len(parts) != 3 || parts[0] == "" {
+		return "", ErrBadCookie
+	}
+	sessionID, kid, mac := parts[0], parts[1], parts[2]
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.maybeReload(false)
+	key := k.find(kid)
+	if key == nil {
+		// probably signed by an instance that rotated since our last check
+		k.maybeReload(true)
+		key = k.find(kid)
+	}
+	if key == nil {
+		return "", ErrBadCookie
+	}
+	if subtle.ConstantTimeCompare([]byte(mac), []byte(cookieMAC(key, sessionID))) != 1 {
+		return "", ErrBadCookie
+	}
+	return sessionID, nil
+}
+
+// Rotate adds a fresh signing key to the shared ring, retiring the oldest
+// beyond the configured maximum.
+func (k *CookieKeyring) Rotate(by string) (*cookieKeyFile, error) {
+	unlock, _, err := lockPath(k.path+".lock", true)
+	if err != nil {
+		return nil, fmt.Errorf("lock cookie keys: %w", err)
+	}
+	defer unlock()
+	ring, err := k.rotateLocked(by)
+	if err != nil {
+		return nil, err
+	}
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	return ring, k.load()
+}
+
+// rotateLocked re-reads the ring from the mount rather than trusting memory,
+// so two instances rotating back to back don't drop each other's keys.
+func (k *CookieKeyring) rotateLocked(by string) (*cookieKeyFile, error) {
+	var ring cookieKeyFile
+	if data, err := os.ReadFile(k.path); err == nil {
+		if err := json.Unmarshal(data, &ring); err != nil {
+			return nil, fmt.Errorf("parse cookie keys: %w", err)
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("read cookie keys: %w", err)
+	}
+
+	id := make([]byte, 4)
+	secret := make([]byte, 32)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+	if _, err := rand.Read(secret); err != nil {
+		return nil, err
+	}
+	key := cookieKey{
+		ID:        hex.EncodeToString(id),
+		Secret:    secret,
+		CreatedAt: time.Now().UTC().Format(time.RFC3339),
+		CreatedBy: by,
+	}
+	ring.Keys = append([]cookieKey{key}, ring.Keys...)
+	if len(ring.Keys) > k.max {
+		ring.Keys = ring.Keys[:k.max]
+	}
+	ring.Signing = key.ID
+
+	data, err := json.MarshalIndent(ring, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("marshal cookie keys: %w", err)
+	}
+	if err := writeFileAtomic(k.path, data, 0600, true); err != nil {
+		return nil, fmt.Errorf("write cookie keys: %w", err)
+	}
+	return &ring, nil
+}
+
+// maybeReload re-stats the key file once per refresh interval, or right away
+// with force set (at most once a second), and reloads it when it changed.
+// callers hold k.mu.
+func (k *CookieKeyring) maybeReload(force bool) {
+	now := time.Now()
+	if force {
+		if now.Sub(k.loadedAt) < time.Second {
+			return
+		}
+	} else if now.Sub(k.checkedAt) < k.refresh {
+		return
+	}
+	k.checkedAt = now
+	if info, err := os.Stat(k.path); err == nil && fileStamp(info) == k.stamp && !force {
+		return
+	}
+	k.load()
+}
+
+// load reads the ring from the mount. on failure the previous keys stay in use.
+// callers hold k.mu.
+func (k *CookieKeyring) load() error {
+	f, err := os.Open(k.path)
+	if err != nil {
+		return fmt.Errorf("open cookie keys: %w", err)
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return fmt.Errorf("stat cookie keys: %w", err)
+	}
+	var ring cookieKeyFile
+	if err := json.NewDecoder(f).Decode(&ring); err != nil {
+		return fmt.Errorf("parse cookie keys: %w", err)
+	}
+	k.keys = ring
+	k.stamp = fileStamp(info)
+	k.loadedAt = time.Now()
+	k.checkedAt = k.loadedAt
+	return nil
+}
+
+func (k *CookieKeyring) find(id string) *cookieKey {
+	for i := range k.keys.Keys {
+		if k.keys.Keys[i].ID == id {
+			return &k.keys.Keys[i]
+		}
+	}
+	return nil
+}
+
+func fileStamp(info os.FileInfo) string {
+	var ino uint64
+	if st, ok := info.Sys().(*syscall.Stat_t); ok {
+		ino = st.Ino
+	}
+	return fmt.Sprintf("%d/%d/%d", ino, info.Size(), info.ModTime().UnixNano())
+}
+
+func cookieMAC(key *cookieKey, sessionID string) string {
+	mac := hmac.New(sha256.New, key.Secret)
+	mac.Write([]byte("session|" + key.ID + "|" + sessionID))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+var cookieKeys *CookieKeyring
+
+// setSessionCookie issues the signed cookie, living as long as the session's absolute TTL.
+func setSessionCookie(w http.ResponseWriter, sess *Session) error {
+	value, err := cookieKeys.Sign(sess.SessionID)
+	if err != nil {
+		return err
+	}
+	expires := time.Now().Add(sessionTTL)
+	if created, err := time.Parse(time.RFC3339, sess.CreatedAt); err == nil {
+		expires = created.Add(sessionTTL)
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookie,
+		Value:    value,
+		Path:     "/",
+		Expires:  expires,
+		MaxAge:   int(time.Until(expires).Seconds()),
+		HttpOnly: true,
+		Secure:   cookieSecure,
+		SameSite: http.SameSiteLaxMode,
+	})
+	return nil
+}
+
+func clearSessionCookie(w http.ResponseWriter) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookie,
+		Value:    "",
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   cookieSecure,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// sessionIDFromRequest returns the session ID from a cookie whose signature
+// checks out, ErrNoCookie without one, or ErrBadCookie if it was tampered with.
+func sessionIDFromRequest(r *http.Request) (string, error) {
+	cookie, err := r.Cookie(sessionCookie)
+	if err != nil {
+		return "", ErrNoCookie
+	}
+	return cookieKeys.Verify(cookie.Value)
+}
+
+// rejectCookie answers 403 for a missing or forged cookie, clearing a forged one.
+func rejectCookie(w http.ResponseWriter, err error) {
+	if errors.Is(err, ErrBadCookie) {
+		clearSessionCookie(w)
+	}
+	w.WriteHeader(http.StatusForbidden)
+	writeJSON(w, map[string]string{"error": err.Error()})
+}
+
+// handleRotateCookieKeys lets an admin rotate the shared signing key.
+func handleRotateCookieKeys(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "POST only", http.StatusMethodNotAllowed)
+		return
+	}
+	admin := currentUser(w, r)
+	if admin == nil {
+		return
+	}
+	if admin.Role != roleAdmin {
+		w.WriteHeader(http.StatusForbidden)
+		writeJSON(w, map[string]string{"error": "admin only"})
+		return
+	}
+
+	ring, err := cookieKeys.Rotate(admin.Username + "@" + hostname)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		writeJSON(w, map[string]string{"error": err.Error()})
+		return
+	}
+	ids := make([]string, 0, len(ring.Keys))
+	for _, key := range ring.Keys {
+		ids = append(ids, key.ID)
+	}
+	writeJSON(w, map[string]interface{}{
+		"signing":   ring.Signing,
+		"active":    ids,
+		"served_by": hostname,
+	})
+}
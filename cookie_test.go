@@ -0,0 +1,112 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCookieKeyringRotation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cookie-keys.json")
+	a, err := NewCookieKeyring(path, time.Hour, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a second instance on the same mount, with no periodic re-stat
+	b, err := NewCookieKeyring(path, time.Hour, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	first, _ := a.Sign("abc123")
+	if id, err := b.Verify(first); err != nil || id != "abc123" {
+		t.Fatalf("instance b rejected a's cookie: %q, %v", id, err)
+	}
+
+	parts := strings.Split(first, ".")
+	for _, forged := range []string{
+		"def456." + parts[1] + "." + parts[2], // swapped session ID
+		parts[0] + "." + parts[1] + ".AAAA",   // bad mac
+		parts[0] + ".ffffffff." + parts[2],    // unknown key
+		"abc123",                              // unsigned, pre-signing cookie
+	} {
+		if _, err := a.Verify(forged); !errors.Is(err, ErrBadCookie) {
+			t.Errorf("Verify(%q) = %v, want ErrBadCookie", forged, err)
+		}
+	}
+
+	// rotation on a: b picks the new key up as soon as it sees a cookie signed with it
+	time.Sleep(1100 * time.Millisecond) // forced reloads are limited to one a second
+	if _, err := a.Rotate("test"); err != nil {
+		t.Fatal(err)
+	}
+	second, _ := a.Sign("abc123")
+	if second == first {
+		t.Fatal("rotation did not change the signing key")
+	}
+	if _, err := b.Verify(second); err != nil {
+		t.Fatalf("instance b rejected cookie signed with rotated key: %v", err)
+	}
+	if _, err := b.Verify(first); err != nil {
+		t.Fatalf("cookie signed before rotation rejected: %v", err)
+	}
+
+	// with max 2, a second rotation retires the original key
+	if _, err := b.Rotate("test"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.Verify(first); !errors.Is(err, ErrBadCookie) {
+		t.Fatalf("cookie signed with retired key: got %v, want ErrBadCookie", err)
+	}
+}
+
+func TestTamperedCookieRejected(t *testing.T) {
+	var err error
+	prevSessions, prevKeys := sessions, cookieKeys
+	t.Cleanup(func() { sessions, cookieKeys = prevSessions, prevKeys })
+	sessions = NewMemorySessionStore(time.Hour, time.Hour)
+	if cookieKeys, err = NewCookieKeyring(filepath.Join(t.TempDir(), "keys.json"), time.Hour, 3); err != nil {
+		t.Fatal(err)
+	}
+
+	sess, _ := sessions.Create("alice", "test-host")
+	rec := httptest.NewRecorder()
+	if err := setSessionCookie(rec, sess); err != nil {
+		t.Fatal(err)
+	}
+	good := rec.Result().Cookies()[0]
+	if !good.HttpOnly || !good.Secure || good.SameSite != http.SameSiteLaxMode || good.MaxAge <= 0 {
+		t.Fatalf("cookie attributes: %+v", good)
+	}
+
+	call := func(h http.HandlerFunc, method, value string) int {
+		req := httptest.NewRequest(method, "/", nil)
+		req.AddCookie(&http.Cookie{Name: sessionCookie, Value: value})
+		req.Header.Set(csrfHeader, sess.CSRFToken)
+		rec := httptest.NewRecorder()
+		h(rec, req)
+		return rec.Code
+	}
+
+	if code := call(handleMe, http.MethodGet, good.Value); code != http.StatusOK {
+		t.Fatalf("me with valid cookie: %d", code)
+	}
+	// the bare session ID the old cookie carried must no longer be accepted
+	if code := call(handleMe, http.MethodGet, sess.SessionID); code != http.StatusForbidden {
+		t.Fatalf("me with unsigned cookie: %d", code)
+	}
+	tampered := good.Value[:len(good.Value)-1] + "x"
+	if code := call(handleLogout, http.MethodPost, tampered); code != http.StatusForbidden {
+		t.Fatalf("logout with tampered cookie: %d", code)
+	}
+	if _, err := sessions.Get(sess.SessionID); err != nil {
+		t.Fatalf("tampered logout deleted the session: %v", err)
+	}
+	if code := call(handleLogout, http.MethodPost, good.Value); code != http.StatusOK {
+		t.Fatalf("logout with valid cookie: %d", code)
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"net/http"
+)
+
+const csrfHeader = "X-CSRF-Token"
+
+func generateCSRFToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// requireSession resolves the signed session cookie to its session. for anything
+// but GET/HEAD it also demands the session's CSRF token in X-CSRF-Token; the
+// token lives in the session on the mount, so a page loaded from one instance
+// can post to any other. it writes the 403 itself and returns nil on failure.
+func requireSession(w http.ResponseWriter, r *http.Request) *Session {
+	sessionID, err := sessionIDFromRequest(r)
+	if err != nil {
+		rejectCookie(w, err)
+		return nil
+	}
+	sess, err := sessions.Get(sessionID)
+	if errors.Is(err, ErrSessionExpired) {
+		sessions.Delete(sessionID)
+		clearSessionCookie(w)
+		w.WriteHeader(http.StatusForbidden)
+		writeJSON(w, map[string]string{"error": "session expired", "expired_at": sess.ExpiresAt})
+		return nil
+	}
+	if err != nil {
+		if rec, ok := revocations.Lookup(sessionID); ok {
+			revokedResponse(w, rec)
+			return nil
+		}
+		w.WriteHeader(http.StatusForbidden)
+		writeJSON(w, map[string]string{"error": "session not found"})
+		return nil
+	}
+
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		token := r.Header.Get(csrfHeader)
+		if token == "" || sess.CSRFToken == "" ||
+			subtle.ConstantTimeCompare([]byte(token), []byte(sess.CSRFToken)) != 1 {
+			w.WriteHeader(http.StatusForbidden)
+			writeJSON(w, map[string]string{"error": "missing or invalid csrf token"})
+			return nil
+		}
+	}
+	return sess
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// TestCSRFAcrossInstances logs in through one file store and posts through a
+// second on the same directory: the token has to come from the shared session.
+func TestCSRFAcrossInstances(t *testing.T) {
+	var err error
+	prevSessions, prevKeys := sessions, cookieKeys
+	t.Cleanup(func() { sessions, cookieKeys = prevSessions, prevKeys })
+	if cookieKeys, err = NewCookieKeyring(filepath.Join(t.TempDir(), "keys.json"), time.Hour, 3); err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	loginInstance := NewFileSessionStore(dir, time.Hour, time.Hour, false)
+	sessions = NewFileSessionStore(dir, time.Hour, time.Hour, false)
+
+	sess, err := loginInstance.Create("alice", "host-a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sess.CSRFToken == "" {
+		t.Fatal("session created without a csrf token")
+	}
+	cookie, _ := cookieKeys.Sign(sess.SessionID)
+
+	post := func(h http.HandlerFunc, path, token string) int {
+		req := httptest.NewRequest(http.MethodPost, path, nil)
+		req.AddCookie(&http.Cookie{Name: sessionCookie, Value: cookie})
+		if token != "" {
+			req.Header.Set(csrfHeader, token)
+		}
+		rec := httptest.NewRecorder()
+		h(rec, req)
+		return rec.Code
+	}
+
+	for _, tc := range []struct {
+		name    string
+		handler http.HandlerFunc
+		path    string
+	}{
+		{"logout", handleLogout, "/api/v1/logout"},
+		{"upload", handleImageUpload, "/api/v1/images/upload"},
+		{"delete", handleImageDelete, "/api/v1/images/delete/x.png"},
+	} {
+		if code := post(tc.handler, tc.path, ""); code != http.StatusForbidden {
+			t.Errorf("%s without token: %d, want 403", tc.name, code)
+		}
+		if code := post(tc.handler, tc.path, "not-the-token"); code != http.StatusForbidden {
+			t.Errorf("%s with wrong token: %d, want 403", tc.name, code)
+		}
+	}
+	if _, err := sessions.Get(sess.SessionID); err != nil {
+		t.Fatalf("rejected requests touched the session: %v", err)
+	}
+
+	if code := post(handleLogout, "/api/v1/logout", sess.CSRFToken); code != http.StatusOK {
+		t.Fatalf("logout with token via second instance: %d", code)
+	}
+	if _, err := loginInstance.Get(sess.SessionID); err == nil {
+		t.Fatal("session survived logout")
+	}
+}
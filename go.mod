@@ -1,3 +1,8 @@
 module github.com/thearyanahmed/nfs-tester
 
 go 1.21
+
+require (
+	golang.org/x/crypto v0.32.0
+	golang.org/x/sys v0.30.0
+)
@@ -25,6 +25,9 @@ func handleImageUpload(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "POST only", http.StatusMethodNotAllowed)
 		return
 	}
+	if requireSession(w, r) == nil {
+		return
+	}
 
 	r.Body = http.MaxBytesReader(w, r.Body, maxUploadSize)
 
@@ -133,6 +136,9 @@ func handleImageDelete(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "POST only", http.StatusMethodNotAllowed)
 		return
 	}
+	if requireSession(w, r) == nil {
+		return
+	}
 
 	filename := strings.TrimPrefix(r.URL.Path, "/api/v1/images/delete/")
 	filename = sanitizeFilename(filename)
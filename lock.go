@@ -0,0 +1,53 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"sync"
+	"syscall"
+)
+
+// processLocks holds one mutex per lock file path. POSIX record locks are owned
+// by the process, so they never exclude two goroutines of the same instance, and
+// closing any descriptor of the file drops every lock the process holds on it.
+var processLocks sync.Map
+
+// lockPath takes an exclusive lock on the file at path (created if missing) that
+// excludes both other goroutines and other instances on the mount, via NLM or
+// NFSv4 LOCK on the wire. with wait unset it returns ok=false instead of blocking
+// when the lock is held. the returned unlock releases both halves.
+func lockPath(path string, wait bool) (unlock func(), ok bool, err error) {
+	v, _ := processLocks.LoadOrStore(path, &sync.Mutex{})
+	mu := v.(*sync.Mutex)
+	if wait {
+		mu.Lock()
+	} else if !mu.TryLock() {
+		return nil, false, nil
+	}
+
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		mu.Unlock()
+		return nil, false, err
+	}
+	cmd := syscall.F_SETLK
+	if wait {
+		cmd = syscall.F_SETLKW
+	}
+	flock := syscall.Flock_t{Type: syscall.F_WRLCK, Whence: 0}
+	if err := syscall.FcntlFlock(f.Fd(), cmd, &flock); err != nil {
+		f.Close()
+		mu.Unlock()
+		if !wait && (errors.Is(err, syscall.EAGAIN) || errors.Is(err, syscall.EACCES)) {
+			return nil, false, nil
+		}
+		return nil, false, err
+	}
+
+	return func() {
+		flock.Type = syscall.F_UNLCK
+		syscall.FcntlFlock(f.Fd(), syscall.F_SETLK, &flock)
+		f.Close()
+		mu.Unlock()
+	}, true, nil
+}
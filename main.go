@@ -10,6 +10,7 @@ import (
 	"os/exec"
 	"os/user"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"time"
 )
@@ -68,6 +69,28 @@ func getEnv(key, fallback string) string {
 	return fallback
 }
 
+// getEnvInt reads a positive int; every setting read through it is a count or
+// size, so zero and negatives fall back like unparsable values.
+func getEnvInt(key string, fallback int) int {
+	if v := os.Getenv(key); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 {
+			return n
+		}
+		log.Printf("ignoring invalid %s=%q, using %d", key, v, fallback)
+	}
+	return fallback
+}
+
+func getEnvDuration(key string, fallback time.Duration) time.Duration {
+	if v := os.Getenv(key); v != "" {
+		if d, err := time.ParseDuration(v); err == nil {
+			return d
+		}
+		log.Printf("ignoring invalid %s=%q, using %s", key, v, fallback)
+	}
+	return fallback
+}
+
 func getHostname() string {
 	h, err := os.Hostname()
 	if err != nil {
@@ -76,16 +99,39 @@ func getHostname() string {
 	return h
 }
 
-var sessions *SessionStore
+var sessions SessionStore
 
 func main() {
+	runChildIfRequested()
+	if len(os.Args) > 1 && os.Args[1] == "migrate-sessions" {
+		os.Exit(runMigrateSessions(os.Args[2:]))
+	}
+
 	log.Printf("nfs-tester starting on %s", listenAddr)
 	log.Printf("NFS path: %s", nfsPath)
 	log.Printf("Session path: %s", sessionPath)
 	log.Printf("Images path: %s", imagesPath)
 	log.Printf("Hostname: %s", hostname)
 
-	sessions = NewSessionStore(sessionPath)
+	var err error
+	sessions, err = newSessionStore(sessionBackend, sessionPath, sessionTTL, sessionIdleTTL, sessionFsync)
+	if err != nil {
+		log.Fatalf("session store: %v", err)
+	}
+	revocations = NewRevocations(filepath.Join(sessionPath, ".revoked"), sessionFsync)
+	StartSweeper(sessions, hostname, sessionSweepInterval)
+	log.Printf("Session store: %s", sessionBackend)
+	log.Printf("Session TTL: %s absolute, %s idle, sweep every %s", sessionTTL, sessionIdleTTL, sessionSweepInterval)
+	cookieKeys, err = NewCookieKeyring(cookieKeysPath, cookieKeysRefresh, cookieKeysMax)
+	if err != nil {
+		log.Fatalf("cookie keys: %v", err)
+	}
+	log.Printf("Cookie keys: %s (secure=%t)", cookieKeysPath, cookieSecure)
+	users, err = NewUserStore(usersPath, bcryptCost)
+	if err != nil {
+		log.Fatalf("user store: %v", err)
+	}
+	log.Printf("Users path: %s", usersPath)
 	os.MkdirAll(imagesPath, 0755)
 	// gvisor gofer ignores mode on mkdir over NFS, force correct perms
 	os.Chmod(imagesPath, 0755)
@@ -104,6 +150,13 @@ func main() {
 	http.HandleFunc("/api/v1/me", handleMe)
 	http.HandleFunc("/api/v1/logout", handleLogout)
 	http.HandleFunc("/api/v1/sessions", handleSessions)
+	http.HandleFunc("/api/v1/sessions/revoke", handleRevokeSessions)
+	http.HandleFunc("/api/v1/session/data", handleSessionData)
+	http.HandleFunc("/api/v1/register", handleRegister)
+	http.HandleFunc("/api/v1/password", handleChangePassword)
+	http.HandleFunc("/api/v1/admin/users", handleAdminUsers)
+	http.HandleFunc("/api/v1/admin/users/", handleAdminUsers)
+	http.HandleFunc("/api/v1/admin/keys/rotate", handleRotateCookieKeys)
 
 	http.HandleFunc("/api/v1/stale-test/write", handleStaleWrite)
 	http.HandleFunc("/api/v1/stale-test/read", handleStaleRead)
@@ -149,10 +202,11 @@ func handleIndex(w http.ResponseWriter, r *http.Request) {
 
   <div class="card">
     <h2>Login</h2>
-    <p>Hardcoded users: alice/password123, bob/password456</p>
+    <p>Seeded users: alice/password123 (admin), bob/password456. Accounts live on the share.</p>
     <input id="username" placeholder="username" value="alice">
     <input id="password" type="password" placeholder="password" value="password123">
     <button onclick="doLogin()">Login</button>
+    <button onclick="doRegister()">Register</button>
   </div>
 
   <div class="card">
@@ -164,9 +218,17 @@ func handleIndex(w http.ResponseWriter, r *http.Request) {
     <div id="result">click a button...</div>
   </div>
 
+  <div class="card">
+    <h2>Revocation</h2>
+    <p>Revokes the current session on one instance, then polls /api/v1/me until every instance answers 403, to show how long NFS attribute caching lets a revoked session live on.</p>
+    <button onclick="runRevokeTest()">Revoke this session and measure</button>
+    <button onclick="doRevokeAll()" class="danger">Log out everywhere</button>
+    <div id="revokeResult" style="white-space:pre-wrap; margin-top:12px; padding:12px; background:#f1f3f5; border:1px solid #dee2e6; border-radius:4px; max-height:50lh; overflow-y:auto; min-height:40px;">click run...</div>
+  </div>
+
   <div class="card">
     <h2>Image Gallery (shared NFS)</h2>
-    <p>Upload images on any instance, view from all instances.</p>
+    <p>Upload images on any instance, view from all instances. Uploading and deleting need a login.</p>
     <input type="file" id="imageFile" accept="image/*">
     <button onclick="doUpload()">Upload</button>
     <button onclick="doGallery()">Refresh Gallery</button>
@@ -198,6 +260,17 @@ func handleIndex(w http.ResponseWriter, r *http.Request) {
 
 <script>
 const out = document.getElementById('result');
+let csrf = '';
+
+// the CSRF token lives in the NFS-stored session; fetch it from whichever
+// instance answers if this page hasn't seen it yet
+async function csrfHeaders() {
+  if (!csrf) {
+    const resp = await fetch('/api/v1/me');
+    if (resp.ok) csrf = (await resp.json()).csrf_token;
+  }
+  return {'X-CSRF-Token': csrf};
+}
 
 async function doLogin() {
   const resp = await fetch('/api/v1/login', {
@@ -208,6 +281,20 @@ async function doLogin() {
       password: document.getElementById('password').value,
     }),
   });
+  const text = await resp.text();
+  csrf = resp.ok ? JSON.parse(text).csrf_token : '';
+  out.textContent = resp.status + ' ' + resp.statusText + '\n' + text;
+}
+
+async function doRegister() {
+  const resp = await fetch('/api/v1/register', {
+    method: 'POST',
+    headers: {'Content-Type': 'application/json'},
+    body: JSON.stringify({
+      username: document.getElementById('username').value,
+      password: document.getElementById('password').value,
+    }),
+  });
   out.textContent = resp.status + ' ' + resp.statusText + '\n' + await resp.text();
 }
 
@@ -217,7 +304,8 @@ async function doMe() {
 }
 
 async function doLogout() {
-  const resp = await fetch('/api/v1/logout', {method: 'POST'});
+  const resp = await fetch('/api/v1/logout', {method: 'POST', headers: await csrfHeaders()});
+  csrf = '';
   out.textContent = resp.status + ' ' + resp.statusText + '\n' + await resp.text();
 }
 
@@ -255,6 +343,64 @@ async function doLoop() {
   }
 }
 
+const revokeOut = document.getElementById('revokeResult');
+
+async function revoke(body) {
+  const resp = await fetch('/api/v1/sessions/revoke', {
+    method: 'POST',
+    headers: Object.assign({'Content-Type': 'application/json'}, await csrfHeaders()),
+    body: JSON.stringify(body),
+  });
+  return resp;
+}
+
+async function doRevokeAll() {
+  const resp = await revoke({});
+  csrf = '';
+  revokeOut.textContent = resp.status + ' ' + resp.statusText + '\n' + await resp.text();
+}
+
+async function runRevokeTest() {
+  const me = await fetch('/api/v1/me');
+  if (!me.ok) { revokeOut.textContent = 'log in first'; return; }
+  const sessionID = (await me.json()).session_id;
+
+  const resp = await revoke({session_id: sessionID});
+  const data = await resp.json();
+  if (!resp.ok) { revokeOut.textContent = 'revoke failed: ' + (data.error || resp.statusText); return; }
+  const start = performance.now();
+  csrf = '';
+  revokeOut.textContent = 'revoked ' + sessionID + ' on ' + data.served_by + ', polling...\n\n';
+
+  // per instance: requests still served after the revocation, and when it first said 403
+  const pods = {};
+  let consecutive403 = 0;
+  for (let i = 0; i < 2000 && consecutive403 < 50 && performance.now() - start < 60000; i++) {
+    const r = await fetch('/api/v1/me');
+    const body = await r.json();
+    const pod = body.served_by || 'unknown';
+    pods[pod] = pods[pod] || {stale: 0, honouredAfter: null, serverLag: null};
+    if (r.ok) {
+      pods[pod].stale++;
+      consecutive403 = 0;
+      revokeOut.textContent += (performance.now() - start).toFixed(0) + 'ms ' + pod + ' STILL VALID\n';
+    } else {
+      consecutive403++;
+      if (pods[pod].honouredAfter === null) {
+        pods[pod].honouredAfter = performance.now() - start;
+        pods[pod].serverLag = body.lag_ms;
+      }
+    }
+  }
+
+  revokeOut.textContent += '\n--- summary ---\n';
+  for (const [pod, p] of Object.entries(pods)) {
+    revokeOut.textContent += pod + ': ' + p.stale + ' stale responses, honoured after '
+      + (p.honouredAfter === null ? 'never' : p.honouredAfter.toFixed(0) + 'ms')
+      + (p.serverLag != null ? ' (server lag ' + p.serverLag + 'ms)' : '') + '\n';
+  }
+}
+
 const gallery = document.getElementById('gallery');
 const imgResult = document.getElementById('imgResult');
 
@@ -264,7 +410,7 @@ async function doUpload() {
   const form = new FormData();
   form.append('image', input.files[0]);
   imgResult.textContent = 'uploading...';
-  const resp = await fetch('/api/v1/images/upload', {method:'POST', body: form});
+  const resp = await fetch('/api/v1/images/upload', {method:'POST', headers: await csrfHeaders(), body: form});
   const data = await resp.json();
   imgResult.textContent = resp.ok
     ? 'uploaded ' + data.filename + ' (' + data.size + ' bytes) via ' + data.served_by
@@ -295,7 +441,7 @@ async function doGallery() {
 }
 
 async function doDeleteImage(name) {
-  const resp = await fetch('/api/v1/images/delete/' + encodeURIComponent(name), {method:'POST'});
+  const resp = await fetch('/api/v1/images/delete/' + encodeURIComponent(name), {method:'POST', headers: await csrfHeaders()});
   const data = await resp.json();
   imgResult.textContent = resp.ok ? 'deleted ' + name : 'error: ' + (data.error || resp.statusText);
   doGallery();
@@ -407,6 +553,17 @@ func handleInfo(w http.ResponseWriter, r *http.Request) {
 		"mount_info":  strings.TrimSpace(mountInfo),
 		"dir_listing": strings.TrimSpace(dirListing),
 	}
+
+	// capacity of each mount we write to
+	statfs := map[string]interface{}{}
+	for _, p := range []string{nfsPath, sessionPath, imagesPath} {
+		if st, err := statfsInfo(p); err == nil {
+			statfs[p] = st
+		} else {
+			statfs[p] = map[string]string{"error": err.Error()}
+		}
+	}
+	info["statfs"] = statfs
 	writeJSON(w, info)
 }
 
@@ -550,8 +707,7 @@ func handleLogin(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	expected, ok := validUsers[req.Username]
-	if !ok || expected != req.Password {
+	if _, err := users.Authenticate(req.Username, req.Password); err != nil {
 		w.WriteHeader(http.StatusUnauthorized)
 		writeJSON(w, map[string]string{"error": "invalid credentials"})
 		return
@@ -564,40 +720,35 @@ func handleLogin(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	http.SetCookie(w, &http.Cookie{
-		Name:  "session",
-		Value: sess.SessionID,
-		Path:  "/",
-	})
+	if err := setSessionCookie(w, sess); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		writeJSON(w, map[string]string{"error": err.Error()})
+		return
+	}
 
 	writeJSON(w, map[string]string{
 		"status":     "ok",
 		"username":   sess.Username,
 		"session_id": sess.SessionID,
+		"csrf_token": sess.CSRFToken,
 		"served_by":  hostname,
 	})
 }
 
 func handleMe(w http.ResponseWriter, r *http.Request) {
-	cookie, err := r.Cookie("session")
-	if err != nil {
-		w.WriteHeader(http.StatusForbidden)
-		writeJSON(w, map[string]string{"error": "no session cookie"})
-		return
-	}
-
-	sess, err := sessions.Get(cookie.Value)
-	if err != nil {
-		w.WriteHeader(http.StatusForbidden)
-		writeJSON(w, map[string]string{"error": "session not found"})
+	sess := requireSession(w, r)
+	if sess == nil {
 		return
 	}
 
 	writeJSON(w, map[string]string{
-		"username":   sess.Username,
-		"session_id": sess.SessionID,
-		"created_by": sess.CreatedBy,
-		"served_by":  hostname,
+		"username":     sess.Username,
+		"session_id":   sess.SessionID,
+		"created_by":   sess.CreatedBy,
+		"last_seen_at": sess.LastSeenAt,
+		"expires_at":   sess.ExpiresAt,
+		"csrf_token":   sess.CSRFToken,
+		"served_by":    hostname,
 	})
 }
 
@@ -607,21 +758,13 @@ func handleLogout(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	cookie, err := r.Cookie("session")
-	if err != nil {
-		w.WriteHeader(http.StatusForbidden)
-		writeJSON(w, map[string]string{"error": "no session cookie"})
+	sess := requireSession(w, r)
+	if sess == nil {
 		return
 	}
 
-	sessions.Delete(cookie.Value)
-
-	http.SetCookie(w, &http.Cookie{
-		Name:   "session",
-		Value:  "",
-		Path:   "/",
-		MaxAge: -1,
-	})
+	sessions.Delete(sess.SessionID)
+	clearSessionCookie(w)
 
 	writeJSON(w, map[string]string{
 		"status":    "ok",
@@ -629,18 +772,42 @@ func handleLogout(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
+const (
+	defaultSessionPage = 100
+	maxSessionPage     = 1000
+)
+
+// handleSessions lists one page of sessions: ?limit=N (default 100, max 1000)
+// and ?cursor=<next_cursor from the previous page>.
 func handleSessions(w http.ResponseWriter, r *http.Request) {
-	list, err := sessions.List()
+	limit := defaultSessionPage
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			w.WriteHeader(http.StatusBadRequest)
+			writeJSON(w, map[string]string{"error": "limit must be a positive integer"})
+			return
+		}
+		limit = min(n, maxSessionPage)
+	}
+
+	list, next, err := sessions.List(r.URL.Query().Get("cursor"), limit)
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		writeJSON(w, map[string]string{"error": err.Error()})
 		return
 	}
+	// other people's tokens and data are nobody's business
+	for i := range list {
+		list[i].CSRFToken = ""
+		list[i].Data = nil
+	}
 
 	writeJSON(w, map[string]interface{}{
-		"sessions":  list,
-		"count":     len(list),
-		"served_by": hostname,
+		"sessions":    list,
+		"count":       len(list),
+		"next_cursor": next,
+		"served_by":   hostname,
 	})
 }
 
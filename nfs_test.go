@@ -6,6 +6,14 @@ import (
 	"testing"
 )
 
+// TestMain lets multi-process ops re-exec the test binary as a child helper.
+func TestMain(m *testing.M) {
+	runChildIfRequested()
+	code := m.Run()
+	cleanupSessionBench()
+	os.Exit(code)
+}
+
 func testBasePath(t *testing.T) string {
 	t.Helper()
 	if p := os.Getenv("NFS_PATH"); p != "" {
@@ -85,21 +93,67 @@ func TestSharedSuite(t *testing.T) {
 
 func TestIndividualOps(t *testing.T) {
 	independent := map[string]bool{
-		"create_file":       true,
-		"mkdir":             true,
-		"nested_mkdir":      true,
-		"large_file_1mb":    true,
-		"concurrent_writes": true,
-		"file_lock":         true,
-		"truncate_file":     true,
-		"mkfifo":            true,
-		"write_binary":      true,
-		"mtime_check":       true,
-		"readdir_many":      true,
-		"sparse_write":      true,
-		"temp_file":         true,
-		"exclusive_create":  true,
-		"seek_read_write":   true,
+		"create_file":               true,
+		"mkdir":                     true,
+		"nested_mkdir":              true,
+		"large_file_1mb":            true,
+		"concurrent_writes":         true,
+		"file_lock":                 true,
+		"truncate_file":             true,
+		"mkfifo":                    true,
+		"write_binary":              true,
+		"mtime_check":               true,
+		"readdir_many":              true,
+		"sparse_write":              true,
+		"temp_file":                 true,
+		"exclusive_create":          true,
+		"seek_read_write":           true,
+		"mtime_granularity":         true,
+		"ctime_on_metadata":         true,
+		"chtimes_roundtrip":         true,
+		"atime_behaviour":           true,
+		"clock_skew":                true,
+		"unlink_while_open":         true,
+		"rmdir_with_open_file":      true,
+		"rename_over_existing":      true,
+		"atomic_replace_fsync":      true,
+		"rename_concurrent_readers": true,
+		"fsync_latency":             true,
+		"fdatasync_latency":         true,
+		"osync_write":               true,
+		"odsync_write":              true,
+		"append_contention":         true,
+		"writeat_disjoint_overlap":  true,
+		"sparse_large_offsets":      true,
+		"max_file_size":             true,
+		"seek_data_hole":            true,
+		"fallocate_modes":           true,
+		"copy_file_range":           true,
+		"ficlone":                   true,
+		"mmap_write_read_fd":        true,
+		"mmap_fd_write_read_map":    true,
+		"mmap_cross_process":        true,
+		"odirect_alignment":         true,
+		"odirect_throughput":        true,
+		"odirect_sees_buffered":     true,
+		"unicode_names":             true,
+		"name_max":                  true,
+		"path_max":                  true,
+		"special_char_names":        true,
+		"case_only_names":           true,
+		"deep_tree":                 true,
+		"wide_dir_readdir":          true,
+		"readdir_during_mutation":   true,
+		"fill_quota":                true,
+		"hardlink_nlink_chain":      true,
+		"inode_stable_rename":       true,
+		"hardlink_write_visibility": true,
+		"unix_socket_file":          true,
+		"mknod_devices":             true,
+		"dangling_symlink":          true,
+		"symlink_loop":              true,
+		"symlink_escape":            true,
+		"lchown_lstat_link":         true,
 	}
 
 	for _, op := range coreOps() {
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Revocation is the tombstone left for a revoked session. instances that later
+// see the session gone use it to tell "revoked" apart from "never existed" and
+// to report how long the revocation took to reach them.
+type Revocation struct {
+	SessionID string `json:"session_id"`
+	Username  string `json:"username"`
+	RevokedAt string `json:"revoked_at"`
+	RevokedBy string `json:"revoked_by"`
+}
+
+// Revocations keeps one tombstone file per revoked session ID in dir. like any
+// other file on the mount, a tombstone written on one instance may take up to
+// the client's attribute cache timeout to become visible on another.
+type Revocations struct {
+	dir   string
+	fsync bool
+}
+
+func NewRevocations(dir string, fsync bool) *Revocations {
+	os.MkdirAll(dir, 0755)
+	// gvisor gofer ignores mode on mkdir over NFS, force correct perms
+	os.Chmod(dir, 0755)
+	return &Revocations{dir: dir, fsync: fsync}
+}
+
+func (rv *Revocations) Record(rec Revocation) error {
+	if err := validSessionID(rec.SessionID); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(rec, "", "  ")
+	if err != nil {
+		return fmt.Errorf("marshal revocation: %w", err)
+	}
+	return writeFileAtomic(filepath.Join(rv.dir, rec.SessionID+".json"), data, 0644, rv.fsync)
+}
+
+func (rv *Revocations) Lookup(sessionID string) (*Revocation, bool) {
+	if rv == nil || validSessionID(sessionID) != nil {
+		return nil, false
+	}
+	data, err := os.ReadFile(filepath.Join(rv.dir, sessionID+".json"))
+	if err != nil {
+		return nil, false
+	}
+	var rec Revocation
+	if json.Unmarshal(data, &rec) != nil {
+		return nil, false
+	}
+	return &rec, true
+}
+
+// Prune drops tombstones older than maxAge; past the absolute session TTL the
+// cookie they answer for has expired anyway.
+func (rv *Revocations) Prune(maxAge time.Duration) int {
+	entries, err := os.ReadDir(rv.dir)
+	if err != nil {
+		return 0
+	}
+	pruned := 0
+	cutoff := time.Now().Add(-maxAge)
+	for _, e := range entries {
+		if !strings.HasSuffix(e.Name(), ".json") || strings.HasPrefix(e.Name(), ".") {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil || info.ModTime().After(cutoff) {
+			continue
+		}
+		if os.Remove(filepath.Join(rv.dir, e.Name())) == nil {
+			pruned++
+		}
+	}
+	return pruned
+}
+
+var revocations *Revocations
+
+// revokedResponse answers a request carrying a cookie for a revoked session,
+// logging how long after the revocation this instance turned it away.
+func revokedResponse(w http.ResponseWriter, rec *Revocation) {
+	lag := int64(-1)
+	if t, err := time.Parse(time.RFC3339Nano, rec.RevokedAt); err == nil {
+		lag = time.Since(t).Milliseconds()
+	}
+	log.Printf("revoked session %s of %s seen %dms after revocation by %s", rec.SessionID, rec.Username, lag, rec.RevokedBy)
+	clearSessionCookie(w)
+	w.WriteHeader(http.StatusForbidden)
+	writeJSON(w, map[string]interface{}{
+		"error":      "session revoked",
+		"revoked_at": rec.RevokedAt,
+		"revoked_by": rec.RevokedBy,
+		"lag_ms":     lag,
+		"served_by":  hostname,
+	})
+}
+
+// handleRevokeSessions revokes sessions: {"session_id": "..."} for one,
+// {"username": "..."} for all of a user's, or {} for all of the caller's own
+// ("log out everywhere"). users may only revoke their own sessions; admins any.
+func handleRevokeSessions(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "POST only", http.StatusMethodNotAllowed)
+		return
+	}
+	caller := currentUser(w, r)
+	if caller == nil {
+		return
+	}
+
+	var req struct {
+		SessionID string `json:"session_id"`
+		Username  string `json:"username"`
+	}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			writeJSON(w, map[string]string{"error": "invalid json"})
+			return
+		}
+	}
+
+	if req.Username != "" && req.Username != caller.Username && caller.Role != roleAdmin {
+		w.WriteHeader(http.StatusForbidden)
+		writeJSON(w, map[string]string{"error": "can only revoke your own sessions"})
+		return
+	}
+
+	var targets []Session
+	switch {
+	case req.SessionID != "":
+		// revoking must not count as activity on the target
+		sess, err := sessions.Peek(req.SessionID)
+		if sess == nil && err != nil {
+			w.WriteHeader(http.StatusNotFound)
+			writeJSON(w, map[string]string{"error": "session not found"})
+			return
+		}
+		targets = []Session{*sess}
+	default:
+		username := req.Username
+		if username == "" {
+			username = caller.Username
+		}
+		list, err := sessions.ListByUser(username)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			writeJSON(w, map[string]string{"error": err.Error()})
+			return
+		}
+		targets = list
+	}
+
+	for _, t := range targets {
+		if t.Username != caller.Username && caller.Role != roleAdmin {
+			w.WriteHeader(http.StatusForbidden)
+			writeJSON(w, map[string]string{"error": "can only revoke your own sessions"})
+			return
+		}
+	}
+
+	revokedAt := time.Now().UTC().Format(time.RFC3339Nano)
+	by := caller.Username + "@" + hostname
+	revoked := []string{}
+	for _, t := range targets {
+		// tombstone first, so no instance can see the session gone without the reason
+		if err := revocations.Record(Revocation{SessionID: t.SessionID, Username: t.Username, RevokedAt: revokedAt, RevokedBy: by}); err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			writeJSON(w, map[string]string{"error": err.Error()})
+			return
+		}
+		if err := sessions.Delete(t.SessionID); err == nil {
+			revoked = append(revoked, t.SessionID)
+		}
+	}
+
+	writeJSON(w, map[string]interface{}{
+		"revoked":    revoked,
+		"count":      len(revoked),
+		"revoked_at": revokedAt,
+		"served_by":  hostname,
+	})
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestFileSessionUserIndex(t *testing.T) {
+	dir := t.TempDir()
+	a := NewFileSessionStore(dir, time.Hour, time.Hour, false)
+	b := NewFileSessionStore(dir, time.Hour, time.Hour, false)
+
+	var bobs []string
+	for i := 0; i < 3; i++ {
+		sess, _ := a.Create("bob", "host-a")
+		bobs = append(bobs, sess.SessionID)
+	}
+	sess, _ := b.Create("bob", "host-b")
+	bobs = append(bobs, sess.SessionID)
+	b.Create("alice", "host-b")
+
+	list, err := a.ListByUser("bob")
+	if err != nil || len(list) != 4 {
+		t.Fatalf("ListByUser(bob) = %d sessions, %v; want 4", len(list), err)
+	}
+
+	b.Delete(bobs[0])
+	if list, _ := a.ListByUser("bob"); len(list) != 3 {
+		t.Fatalf("after delete: %d sessions, want 3", len(list))
+	}
+	data, _ := os.ReadFile(filepath.Join(dir, userIndexDir, "bob"))
+	if n := strings.Count(string(data), "\n"); n != 3 {
+		t.Fatalf("index not pruned: %d entries", n)
+	}
+	if _, err := a.ListByUser("../etc"); err == nil {
+		t.Fatal("path in username accepted")
+	}
+}
+
+// TestFileSessionUserIndexBackfill starts from sessions written before the
+// index existed, sharded and flat; the first lookup must find all of them.
+func TestFileSessionUserIndexBackfill(t *testing.T) {
+	dir := t.TempDir()
+	store := NewFileSessionStore(dir, time.Hour, time.Hour, false)
+	store.Create("bob", "host-a")
+	store.Create("bob", "host-a")
+	store.Create("alice", "host-a")
+	os.RemoveAll(filepath.Join(dir, userIndexDir))
+	flatID, _ := generateSessionID()
+	os.WriteFile(store.flatPath(flatID), []byte(`{"username":"bob","session_id":"`+flatID+`"}`), 0644)
+
+	list, err := store.ListByUser("bob")
+	if err != nil || len(list) != 3 {
+		t.Fatalf("ListByUser(bob) = %d sessions, %v; want 3", len(list), err)
+	}
+	if list, _ := store.ListByUser("alice"); len(list) != 1 {
+		t.Fatalf("ListByUser(alice) = %d sessions, want 1", len(list))
+	}
+
+	// built once: sessions created afterwards are indexed by Create alone
+	store.Create("bob", "host-b")
+	if list, _ := store.ListByUser("bob"); len(list) != 4 {
+		t.Fatalf("after create: %d sessions, want 4", len(list))
+	}
+	data, _ := os.ReadFile(filepath.Join(dir, userIndexDir, "bob"))
+	if n := strings.Count(string(data), "\n"); n != 4 {
+		t.Fatalf("index has %d entries, want 4", n)
+	}
+}
+
+func TestRevokeSessions(t *testing.T) {
+	var err error
+	prevSessions, prevKeys, prevUsers, prevRevocations := sessions, cookieKeys, users, revocations
+	t.Cleanup(func() { sessions, cookieKeys, users, revocations = prevSessions, prevKeys, prevUsers, prevRevocations })
+	dir := t.TempDir()
+	sessions = NewFileSessionStore(dir, time.Hour, time.Hour, false)
+	revocations = NewRevocations(filepath.Join(dir, ".revoked"), false)
+	if cookieKeys, err = NewCookieKeyring(filepath.Join(t.TempDir(), "keys.json"), time.Hour, 3); err != nil {
+		t.Fatal(err)
+	}
+	if users, err = NewUserStore(t.TempDir(), bcrypt.MinCost); err != nil {
+		t.Fatal(err)
+	}
+
+	alice, _ := sessions.Create("alice", "test-host")
+	bob1, _ := sessions.Create("bob", "test-host")
+	bob2, _ := sessions.Create("bob", "test-host")
+
+	call := func(h http.HandlerFunc, sess *Session, body string) (int, map[string]interface{}) {
+		method := http.MethodPost
+		if body == "" {
+			method = http.MethodGet
+		}
+		req := httptest.NewRequest(method, "/", strings.NewReader(body))
+		cookie, _ := cookieKeys.Sign(sess.SessionID)
+		req.AddCookie(&http.Cookie{Name: sessionCookie, Value: cookie})
+		req.Header.Set(csrfHeader, sess.CSRFToken)
+		rec := httptest.NewRecorder()
+		h(rec, req)
+		var out map[string]interface{}
+		json.Unmarshal(rec.Body.Bytes(), &out)
+		return rec.Code, out
+	}
+
+	if code, _ := call(handleRevokeSessions, bob1, `{"session_id":"`+alice.SessionID+`"}`); code != http.StatusForbidden {
+		t.Fatalf("bob revoking alice's session: %d, want 403", code)
+	}
+	if code, _ := call(handleRevokeSessions, bob1, `{"username":"alice"}`); code != http.StatusForbidden {
+		t.Fatalf("bob revoking all of alice's sessions: %d, want 403", code)
+	}
+
+	code, out := call(handleRevokeSessions, alice, `{"username":"bob"}`)
+	if code != http.StatusOK || out["count"] != float64(2) {
+		t.Fatalf("admin revoke of bob: %d %v", code, out)
+	}
+	for _, s := range []*Session{bob1, bob2} {
+		code, out := call(handleMe, s, "")
+		if code != http.StatusForbidden || out["error"] != "session revoked" || out["lag_ms"] == nil {
+			t.Fatalf("me after revoke: %d %v", code, out)
+		}
+	}
+	if code, _ := call(handleMe, alice, ""); code != http.StatusOK {
+		t.Fatalf("alice's session hit by bob's revoke: %d", code)
+	}
+
+	// log out everywhere
+	alice2, _ := sessions.Create("alice", "other-host")
+	if code, out := call(handleRevokeSessions, alice, `{}`); code != http.StatusOK || out["count"] != float64(2) {
+		t.Fatalf("logout everywhere: %d %v", code, out)
+	}
+	if code, _ := call(handleMe, alice2, ""); code != http.StatusForbidden {
+		t.Fatalf("other session survived logout everywhere: %d", code)
+	}
+
+	if n := revocations.Prune(time.Hour); n != 0 {
+		t.Fatalf("pruned %d fresh tombstones", n)
+	}
+	if n := revocations.Prune(-time.Second); n != 4 {
+		t.Fatalf("pruned %d tombstones, want 4", n)
+	}
+}
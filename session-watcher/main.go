@@ -3,21 +3,44 @@ package main
 import (
 	"crypto/md5"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"html"
 	"log"
 	"net/http"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
+	"sync"
+	"syscall"
 	"time"
+	"unsafe"
 )
 
 var (
-	sessionPath = getEnv("SESSION_PATH", "/data/sessions")
-	listenAddr  = getEnv("LISTEN_ADDR", ":8081")
-	hostname    = getHostname()
+	sessionPath  = getEnv("SESSION_PATH", "/data/sessions")
+	listenAddr   = getEnv("LISTEN_ADDR", ":8081")
+	watchMode    = getEnv("WATCH_MODE", "auto")
+	pollInterval = getEnvDuration("WATCH_POLL_INTERVAL", 2*time.Second)
+	hostname     = getHostname()
 )
 
+// fullRescanEvery forces an MD5 of every file each this many polls, for
+// rewrites that land within the mount's mtime granularity or attribute cache.
+// in inotify mode it is also how many poll intervals apart the safety rescans run.
+const fullRescanEvery = 15
+
+// localFilesystems are the statfs magics auto mode trusts inotify on. anything
+// else may be NFS underneath (gVisor gofer, overlay, FUSE), where inotify never
+// sees other instances' writes, so it is polled.
+var localFilesystems = map[int64]string{
+	0xEF53:     "ext4",
+	0x58465342: "xfs",
+	0x9123683E: "btrfs",
+	0x01021994: "tmpfs",
+}
+
 type SessionDigest struct {
 	Filename  string `json:"filename"`
 	Username  string `json:"username"`
@@ -26,18 +49,38 @@ type SessionDigest struct {
 	CreatedAt string `json:"created_at"`
 }
 
+// SessionEvent is one change pushed to browsers: create, modify or delete.
+type SessionEvent struct {
+	Type   string        `json:"type"`
+	Digest SessionDigest `json:"digest"`
+	Source string        `json:"source"` // inotify or poll
+	At     string        `json:"at"`
+}
+
+var watch *Watcher
+
 func main() {
 	log.Printf("session-watcher v2 starting on %s", listenAddr)
 	log.Printf("watching: %s", sessionPath)
 	log.Printf("hostname: %s", hostname)
 
+	watch = NewWatcher(sessionPath)
+	mode := watch.Start(watchMode, pollInterval)
+	if mode == "inotify" {
+		log.Printf("change detection: inotify (safety rescan every %s)", pollInterval*fullRescanEvery)
+	} else {
+		log.Printf("change detection: poll every %s", pollInterval)
+	}
+
 	// routes with /watcher prefix (App Platform ingress forwards prefix as-is)
 	http.HandleFunc("/watcher/", handleIndex)
 	http.HandleFunc("/watcher/health", handleHealth)
 	http.HandleFunc("/watcher/api/v1/digest", handleDigest)
+	http.HandleFunc("/watcher/api/v1/events", handleEvents)
 
 	// bare routes for health checks and direct access
 	http.HandleFunc("/health", handleHealth)
+	http.HandleFunc("/api/v1/events", handleEvents)
 	http.HandleFunc("/", handleIndex)
 
 	log.Fatal(http.ListenAndServe(listenAddr, nil))
@@ -49,31 +92,83 @@ func handleHealth(w http.ResponseWriter, r *http.Request) {
 }
 
 func handleDigest(w http.ResponseWriter, r *http.Request) {
-	digests := readSessions()
+	digests := watch.Snapshot()
 	w.Header().Set("Content-Type", "application/json")
 	enc := json.NewEncoder(w)
 	enc.SetIndent("", "  ")
 	enc.Encode(map[string]interface{}{
 		"sessions":  digests,
 		"count":     len(digests),
+		"mode":      watch.Mode(),
+		"served_by": hostname,
+	})
+}
+
+// handleEvents streams session changes as server-sent events. every connection
+// (and so every browser reconnect) starts with a snapshot event holding the
+// full list, followed by create/modify/delete events as they are detected.
+func handleEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+
+	events := watch.Subscribe()
+	defer watch.Unsubscribe(events)
+
+	writeEvent(w, "snapshot", map[string]interface{}{
+		"sessions":  watch.Snapshot(),
+		"mode":      watch.Mode(),
 		"served_by": hostname,
 	})
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(15 * time.Second)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case ev := <-events:
+			writeEvent(w, ev.Type, ev)
+		case <-heartbeat.C:
+			// keeps proxies from timing out an idle stream
+			fmt.Fprint(w, ": ping\n\n")
+		}
+		flusher.Flush()
+	}
+}
+
+func writeEvent(w http.ResponseWriter, name string, v interface{}) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return
+	}
+	fmt.Fprintf(w, "event: %s\ndata: %s\n\n", name, data)
 }
 
 func handleIndex(w http.ResponseWriter, r *http.Request) {
-	if r.URL.Path != "/" && r.URL.Path != "/watcher/" && r.URL.Path != "/watcher" {
+	if r.URL.Path == "/watcher" {
+		// the page's relative URLs only resolve under the prefix with a trailing slash
+		http.Redirect(w, r, "/watcher/", http.StatusMovedPermanently)
+		return
+	}
+	if r.URL.Path != "/" && r.URL.Path != "/watcher/" {
 		http.NotFound(w, r)
 		return
 	}
 
-	digests := readSessions()
+	digests := watch.Snapshot()
 
 	w.Header().Set("Content-Type", "text/html; charset=utf-8")
 	fmt.Fprintf(w, `<!DOCTYPE html>
 <html>
 <head>
   <title>Session Watcher</title>
-  <meta http-equiv="refresh" content="5">
   <style>
     body { font-family: monospace; max-width: 800px; margin: 40px auto; padding: 0 20px; background: #f8f9fa; color: #212529; }
     h1 { color: #0056b3; }
@@ -85,6 +180,10 @@ func handleIndex(w http.ResponseWriter, r *http.Request) {
     .empty { padding: 20px; text-align: center; color: #868e96; }
     .md5 { font-family: monospace; font-size: 11px; color: #495057; }
     .badge { display: inline-block; padding: 2px 8px; border-radius: 10px; font-size: 11px; background: #0056b3; color: #fff; }
+    .live { color: #2b8a3e; }
+    .offline { color: #c92a2a; }
+    tr.changed td { background: #fff3bf; transition: background 1s; }
+    #log { margin-top: 16px; font-size: 12px; color: #495057; list-style: none; padding: 0; }
   </style>
 </head>
 <body>
@@ -92,75 +191,513 @@ func handleIndex(w http.ResponseWriter, r *http.Request) {
   <p class="info">
     Read-only view of <code>%s</code><br>
     Served by: <span class="served-by">%s</span> |
-    Sessions: <span class="badge">%d</span> |
-    Auto-refreshes every 5s
+    Sessions: <span class="badge" id="count">%d</span> |
+    Detection: <span id="mode">%s</span> |
+    <span id="status" class="offline">connecting…</span>
   </p>
-`, sessionPath, hostname, len(digests))
+  <div class="empty" id="empty"%s>no sessions found — log in on the main app to create one</div>
+  <table id="sessions"%s>
+    <thead><tr><th>User</th><th>File</th><th>MD5</th><th>Size</th><th>Age</th></tr></thead>
+    <tbody>
+`, html.EscapeString(sessionPath), html.EscapeString(hostname), len(digests), watch.Mode(),
+		hiddenIf(len(digests) > 0), hiddenIf(len(digests) == 0))
 
-	if len(digests) == 0 {
-		fmt.Fprint(w, `<div class="empty">no sessions found — log in on the main app to create one</div>`)
-	} else {
-		fmt.Fprint(w, `<table>
-  <tr><th>User</th><th>File</th><th>MD5</th><th>Size</th><th>Age</th></tr>
-`)
-		for _, d := range digests {
-			age := "?"
-			if t, err := time.Parse(time.RFC3339, d.CreatedAt); err == nil {
-				age = time.Since(t).Truncate(time.Second).String()
-			}
-			fmt.Fprintf(w, `  <tr><td>%s</td><td>%s</td><td class="md5">%s</td><td>%d B</td><td>%s</td></tr>
-`, d.Username, d.Filename, d.MD5, d.Size, age)
+	for _, d := range digests {
+		age := "?"
+		if t, err := time.Parse(time.RFC3339, d.CreatedAt); err == nil {
+			age = time.Since(t).Truncate(time.Second).String()
 		}
-		fmt.Fprint(w, `</table>`)
+		fmt.Fprintf(w, `      <tr id="row-%s" data-created="%s"><td>%s</td><td>%s</td><td class="md5">%s</td><td>%d B</td><td>%s</td></tr>
+`, html.EscapeString(d.Filename), html.EscapeString(d.CreatedAt), html.EscapeString(d.Username), html.EscapeString(d.Filename), d.MD5, d.Size, age)
 	}
 
-	fmt.Fprint(w, `
+	fmt.Fprint(w, `    </tbody>
+  </table>
+  <ul id="log"></ul>
   <p style="margin-top:20px; font-size:12px; color:#868e96;">
     this component only mounts <code>/data/sessions</code> — it cannot see <code>/data/images</code>
   </p>
+<script>
+const tbody = document.querySelector('#sessions tbody');
+
+function age(createdAt) {
+  const t = Date.parse(createdAt);
+  if (isNaN(t)) return '?';
+  const s = Math.max(0, Math.floor((Date.now() - t) / 1000));
+  const h = Math.floor(s / 3600), m = Math.floor(s % 3600 / 60);
+  return (h ? h + 'h' : '') + (h || m ? m + 'm' : '') + (s % 60) + 's';
+}
+
+function renderRow(tr, d) {
+  tr.id = 'row-' + d.filename;
+  tr.dataset.created = d.created_at;
+  tr.replaceChildren();
+  [d.username, d.filename, d.md5, d.size + ' B', age(d.created_at)].forEach((v, i) => {
+    const td = document.createElement('td');
+    td.textContent = v;
+    if (i === 2) td.className = 'md5';
+    tr.appendChild(td);
+  });
+}
+
+function upsert(d, flash) {
+  let tr = document.getElementById('row-' + d.filename);
+  if (!tr) {
+    tr = document.createElement('tr');
+    tbody.appendChild(tr);
+  }
+  renderRow(tr, d);
+  if (flash) {
+    tr.classList.add('changed');
+    setTimeout(() => tr.classList.remove('changed'), 1500);
+  }
+}
+
+function updateCount() {
+  const n = tbody.rows.length;
+  document.getElementById('count').textContent = n;
+  document.getElementById('empty').hidden = n > 0;
+  document.getElementById('sessions').hidden = n === 0;
+}
+
+function logEvent(ev) {
+  const li = document.createElement('li');
+  li.textContent = ev.at + ' ' + ev.type + ' ' + ev.digest.filename +
+    (ev.digest.username ? ' (' + ev.digest.username + ')' : '') + ' via ' + ev.source;
+  const log = document.getElementById('log');
+  log.prepend(li);
+  while (log.children.length > 20) log.lastChild.remove();
+}
+
+// resolve against the page's own directory, whichever prefix it is served under
+const base = location.pathname.endsWith('/') ? location.pathname : location.pathname + '/';
+const es = new EventSource(base + 'api/v1/events');
+es.onopen = () => {
+  const s = document.getElementById('status');
+  s.textContent = 'live';
+  s.className = 'live';
+};
+es.onerror = () => {
+  // EventSource reconnects on its own; the next snapshot resyncs the table
+  const s = document.getElementById('status');
+  s.textContent = 'reconnecting…';
+  s.className = 'offline';
+};
+es.addEventListener('snapshot', e => {
+  const snap = JSON.parse(e.data);
+  document.getElementById('mode').textContent = snap.mode;
+  tbody.replaceChildren();
+  (snap.sessions || []).forEach(d => upsert(d, false));
+  updateCount();
+});
+['create', 'modify'].forEach(type => es.addEventListener(type, e => {
+  const ev = JSON.parse(e.data);
+  upsert(ev.digest, true);
+  updateCount();
+  logEvent(ev);
+}));
+es.addEventListener('delete', e => {
+  const ev = JSON.parse(e.data);
+  const tr = document.getElementById('row-' + ev.digest.filename);
+  if (tr) tr.remove();
+  updateCount();
+  logEvent(ev);
+});
+
+setInterval(() => {
+  for (const tr of tbody.rows) tr.cells[4].textContent = age(tr.dataset.created);
+}, 1000);
+</script>
 </body>
 </html>`)
 }
 
-func readSessions() []SessionDigest {
-	entries, err := os.ReadDir(sessionPath)
-	if err != nil {
-		log.Printf("readdir %s: %v", sessionPath, err)
-		return nil
+func hiddenIf(hide bool) string {
+	if hide {
+		return " hidden"
 	}
+	return ""
+}
 
-	var digests []SessionDigest
-	for _, e := range entries {
-		if !strings.HasSuffix(e.Name(), ".json") || e.IsDir() {
-			continue
+// fileState is what the watcher last saw of one session file.
+type fileState struct {
+	modTime time.Time
+	size    int64
+	digest  SessionDigest
+}
+
+// Watcher keeps an in-memory view of the session dir and tells subscribers
+// when a session file appears, changes content or goes away.
+//
+// inotify only reports changes made through the local kernel, so on NFS it
+// never sees what other instances write; there the watcher polls instead,
+// comparing mtime and size on every pass and MD5 when those moved. sliding
+// expiry bumps a session's mtime without touching its content, so only an MD5
+// change counts as a modify.
+type Watcher struct {
+	root string
+
+	mu    sync.Mutex
+	files map[string]*fileState // by path
+	mode  string
+	subs  map[chan SessionEvent]struct{}
+
+	done      chan struct{}
+	closeOnce sync.Once
+	inotifyFD int
+	rootWD    int
+}
+
+func NewWatcher(root string) *Watcher {
+	return &Watcher{
+		root:      root,
+		files:     make(map[string]*fileState),
+		subs:      make(map[chan SessionEvent]struct{}),
+		done:      make(chan struct{}),
+		inotifyFD: -1,
+	}
+}
+
+// Close stops change detection. dropping the root watch queues an IN_IGNORED
+// event, which wakes the inotify loop so it can see it is done.
+func (wt *Watcher) Close() {
+	wt.closeOnce.Do(func() {
+		close(wt.done)
+		if wt.inotifyFD >= 0 {
+			syscall.InotifyRmWatch(wt.inotifyFD, uint32(wt.rootWD))
+		}
+	})
+}
+
+func (wt *Watcher) closed() bool {
+	select {
+	case <-wt.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// Start takes the initial snapshot and begins change detection. mode is auto,
+// inotify or poll; auto uses inotify only on a known local filesystem and polls
+// otherwise or when inotify can't be set up. inotify mode keeps a slow rescan
+// running as well, in case the mount isn't as local as it looks. the resolved
+// mode is returned.
+func (wt *Watcher) Start(mode string, interval time.Duration) string {
+	if mode == "auto" {
+		mode = "poll"
+		var st syscall.Statfs_t
+		if err := syscall.Statfs(wt.root, &st); err == nil {
+			fs, ok := localFilesystems[int64(st.Type)]
+			if ok {
+				mode = "inotify"
+			} else {
+				fs = fmt.Sprintf("0x%x", st.Type)
+			}
+			log.Printf("%s is on %s, using %s", wt.root, fs, mode)
+		}
+	}
+	if mode != "inotify" {
+		mode = "poll"
+	}
+	wt.mu.Lock()
+	wt.mode = mode
+	wt.mu.Unlock()
+
+	// watches go in before the first scan, so nothing written in between is missed
+	if mode == "inotify" {
+		if err := wt.startInotify(interval); err != nil {
+			log.Printf("inotify unavailable, polling instead: %v", err)
+			mode = "poll"
+			wt.mu.Lock()
+			wt.mode = mode
+			wt.mu.Unlock()
+		}
+	}
+	wt.scan("poll", true)
+	if mode == "poll" {
+		go wt.pollLoop(interval)
+	} else {
+		go wt.pollLoop(interval * fullRescanEvery)
+	}
+	return mode
+}
+
+func (wt *Watcher) Mode() string {
+	wt.mu.Lock()
+	defer wt.mu.Unlock()
+	return wt.mode
+}
+
+// Snapshot returns the current digests ordered by filename.
+func (wt *Watcher) Snapshot() []SessionDigest {
+	wt.mu.Lock()
+	defer wt.mu.Unlock()
+	digests := make([]SessionDigest, 0, len(wt.files))
+	for _, f := range wt.files {
+		digests = append(digests, f.digest)
+	}
+	sort.Slice(digests, func(i, j int) bool { return digests[i].Filename < digests[j].Filename })
+	return digests
+}
+
+func (wt *Watcher) Subscribe() chan SessionEvent {
+	ch := make(chan SessionEvent, 64)
+	wt.mu.Lock()
+	wt.subs[ch] = struct{}{}
+	wt.mu.Unlock()
+	return ch
+}
+
+func (wt *Watcher) Unsubscribe(ch chan SessionEvent) {
+	wt.mu.Lock()
+	delete(wt.subs, ch)
+	wt.mu.Unlock()
+}
+
+// publish hands ev to every subscriber without blocking; a browser too slow to
+// keep up misses events until its next reconnect snapshot. callers hold wt.mu.
+func (wt *Watcher) publish(ev SessionEvent) {
+	for ch := range wt.subs {
+		select {
+		case ch <- ev:
+		default:
+		}
+	}
+}
+
+func (wt *Watcher) pollLoop(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for n := 1; ; n++ {
+		select {
+		case <-wt.done:
+			return
+		case <-ticker.C:
 		}
+		wt.scan("poll", n%fullRescanEvery == 0)
+	}
+}
 
-		path := filepath.Join(sessionPath, e.Name())
-		data, err := os.ReadFile(path)
+// scan walks the whole dir, checking every session file and reporting the
+// ones that disappeared. with force set every file is re-hashed.
+func (wt *Watcher) scan(source string, force bool) {
+	seen := make(map[string]bool)
+	err := filepath.WalkDir(wt.root, func(path string, e os.DirEntry, err error) error {
 		if err != nil {
-			continue
+			if path == wt.root {
+				return err
+			}
+			return nil
+		}
+		if skipEntry(path, wt.root, e.Name(), e.IsDir()) {
+			if e.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if e.IsDir() {
+			return nil
+		}
+		seen[path] = true
+		wt.check(path, source, force)
+		return nil
+	})
+	if err != nil {
+		log.Printf("walk %s: %v", wt.root, err)
+		return
+	}
+
+	wt.mu.Lock()
+	defer wt.mu.Unlock()
+	for path, f := range wt.files {
+		if !seen[path] {
+			delete(wt.files, path)
+			wt.publish(newEvent("delete", f.digest, source))
 		}
+	}
+}
 
-		info, _ := e.Info()
+// skipEntry is true for temp files from in-flight writes, the sweeper's
+// lock/status files and dot dirs such as the user index, and for anything
+// that isn't a session file.
+func skipEntry(path, root, name string, isDir bool) bool {
+	if path == root {
+		return false
+	}
+	if strings.HasPrefix(name, ".") {
+		return true
+	}
+	return !isDir && !strings.HasSuffix(name, ".json")
+}
 
-		// parse username from session json
-		var sess struct {
-			Username  string `json:"username"`
-			CreatedAt string `json:"created_at"`
+// check compares one file against the last state seen and publishes the
+// difference, if any. a file that can't be stat'ed counts as deleted.
+func (wt *Watcher) check(path, source string, force bool) {
+	info, err := os.Stat(path)
+	if err != nil {
+		wt.mu.Lock()
+		defer wt.mu.Unlock()
+		if f, ok := wt.files[path]; ok {
+			delete(wt.files, path)
+			wt.publish(newEvent("delete", f.digest, source))
 		}
-		json.Unmarshal(data, &sess)
+		return
+	}
+
+	wt.mu.Lock()
+	prev, known := wt.files[path]
+	wt.mu.Unlock()
+	if known && !force && prev.modTime.Equal(info.ModTime()) && prev.size == info.Size() {
+		return
+	}
 
-		hash := fmt.Sprintf("%x", md5.Sum(data))
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return
+	}
+	var sess struct {
+		Username  string `json:"username"`
+		CreatedAt string `json:"created_at"`
+	}
+	json.Unmarshal(data, &sess)
+	digest := SessionDigest{
+		Filename:  filepath.Base(path),
+		Username:  sess.Username,
+		MD5:       fmt.Sprintf("%x", md5.Sum(data)),
+		Size:      int64(len(data)),
+		CreatedAt: sess.CreatedAt,
+	}
 
-		digests = append(digests, SessionDigest{
-			Filename:  e.Name(),
-			Username:  sess.Username,
-			MD5:       hash,
-			Size:      info.Size(),
-			CreatedAt: sess.CreatedAt,
-		})
+	wt.mu.Lock()
+	defer wt.mu.Unlock()
+	prev, known = wt.files[path]
+	wt.files[path] = &fileState{modTime: info.ModTime(), size: info.Size(), digest: digest}
+	switch {
+	case !known:
+		wt.publish(newEvent("create", digest, source))
+	case prev.digest.MD5 != digest.MD5:
+		wt.publish(newEvent("modify", digest, source))
+	}
+}
+
+func newEvent(typ string, d SessionDigest, source string) SessionEvent {
+	return SessionEvent{Type: typ, Digest: d, Source: source, At: time.Now().UTC().Format(time.RFC3339Nano)}
+}
+
+const inotifyMask = syscall.IN_CREATE | syscall.IN_CLOSE_WRITE | syscall.IN_MOVED_TO |
+	syscall.IN_MOVED_FROM | syscall.IN_DELETE | syscall.IN_DELETE_SELF
+
+// startInotify watches the session dir and its shard dirs, adding a watch for
+// every shard dir created later. if reading events ever fails the watcher
+// falls back to polling every fallback.
+func (wt *Watcher) startInotify(fallback time.Duration) error {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC)
+	if err != nil {
+		return fmt.Errorf("inotify_init1: %w", err)
+	}
+	dirs := make(map[int32]string)
+	if err := addWatches(fd, wt.root, dirs); err != nil {
+		syscall.Close(fd)
+		return err
+	}
+	for wd, dir := range dirs {
+		if dir == wt.root {
+			wt.rootWD = int(wd)
+		}
+	}
+	wt.inotifyFD = fd
+	go wt.inotifyLoop(fd, dirs, fallback)
+	return nil
+}
+
+// addWatches adds a watch on dir and every non-dot dir below it.
+func addWatches(fd int, dir string, dirs map[int32]string) error {
+	return filepath.WalkDir(dir, func(path string, e os.DirEntry, err error) error {
+		if err != nil {
+			if path == dir {
+				return err
+			}
+			return nil
+		}
+		if !e.IsDir() {
+			return nil
+		}
+		if path != dir && strings.HasPrefix(e.Name(), ".") {
+			return filepath.SkipDir
+		}
+		wd, err := syscall.InotifyAddWatch(fd, path, inotifyMask)
+		if err != nil {
+			return fmt.Errorf("inotify_add_watch %s: %w", path, err)
+		}
+		dirs[int32(wd)] = path
+		return nil
+	})
+}
+
+func (wt *Watcher) inotifyLoop(fd int, dirs map[int32]string, fallback time.Duration) {
+	defer syscall.Close(fd)
+	buf := make([]byte, 64*(syscall.SizeofInotifyEvent+syscall.NAME_MAX+1))
+	for {
+		n, err := syscall.Read(fd, buf)
+		if wt.closed() {
+			return
+		}
+		if errors.Is(err, syscall.EINTR) {
+			continue
+		}
+		if err != nil || n <= 0 {
+			// without events we'd silently go stale; keep going by polling
+			log.Printf("inotify read failed, polling instead: %v", err)
+			wt.mu.Lock()
+			wt.mode = "poll"
+			wt.mu.Unlock()
+			wt.pollLoop(fallback)
+			return
+		}
+
+		for off := 0; off+syscall.SizeofInotifyEvent <= n; {
+			ev := (*syscall.InotifyEvent)(unsafe.Pointer(&buf[off]))
+			nameBytes := buf[off+syscall.SizeofInotifyEvent : off+syscall.SizeofInotifyEvent+int(ev.Len)]
+			name := strings.TrimRight(string(nameBytes), "\x00")
+			off += syscall.SizeofInotifyEvent + int(ev.Len)
+
+			if ev.Mask&syscall.IN_Q_OVERFLOW != 0 {
+				wt.scan("inotify", false)
+				continue
+			}
+			dir, ok := dirs[ev.Wd]
+			if !ok {
+				continue
+			}
+			if ev.Mask&(syscall.IN_DELETE_SELF|syscall.IN_IGNORED) != 0 {
+				delete(dirs, ev.Wd)
+				continue
+			}
+			path := filepath.Join(dir, name)
+			isDir := ev.Mask&syscall.IN_ISDIR != 0
+			if name == "" || skipEntry(path, wt.root, name, isDir) {
+				continue
+			}
+			if isDir {
+				if ev.Mask&(syscall.IN_CREATE|syscall.IN_MOVED_TO) != 0 {
+					// files may land in a new shard before its watch exists
+					if err := addWatches(fd, path, dirs); err != nil {
+						log.Printf("%v", err)
+					}
+					wt.scan("inotify", false)
+				} else if ev.Mask&syscall.IN_MOVED_FROM != 0 {
+					wt.scan("inotify", false)
+				}
+				continue
+			}
+			if ev.Mask == syscall.IN_CREATE {
+				// an empty file still being written; IN_CLOSE_WRITE follows
+				continue
+			}
+			wt.check(path, "inotify", false)
+		}
 	}
-	return digests
 }
 
 func getEnv(key, fallback string) string {
@@ -170,6 +707,15 @@ func getEnv(key, fallback string) string {
 	return fallback
 }
 
+func getEnvDuration(key string, fallback time.Duration) time.Duration {
+	if v := os.Getenv(key); v != "" {
+		if d, err := time.ParseDuration(v); err == nil {
+			return d
+		}
+	}
+	return fallback
+}
+
 func getHostname() string {
 	h, err := os.Hostname()
 	if err != nil {
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func writeSession(t *testing.T, path, username string) {
+	t.Helper()
+	os.MkdirAll(filepath.Dir(path), 0755)
+	tmp := filepath.Join(filepath.Dir(path), ".tmp-"+filepath.Base(path))
+	data := `{"username":"` + username + `","created_at":"2026-01-01T00:00:00Z"}`
+	if err := os.WriteFile(tmp, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func nextEvent(t *testing.T, ch chan SessionEvent, want string) SessionEvent {
+	t.Helper()
+	select {
+	case ev := <-ch:
+		if ev.Type != want {
+			t.Fatalf("got %s event for %s, want %s", ev.Type, ev.Digest.Filename, want)
+		}
+		return ev
+	case <-time.After(3 * time.Second):
+		t.Fatalf("no %s event", want)
+	}
+	return SessionEvent{}
+}
+
+func noEvent(t *testing.T, ch chan SessionEvent, wait time.Duration) {
+	t.Helper()
+	select {
+	case ev := <-ch:
+		t.Fatalf("unexpected %s event for %s", ev.Type, ev.Digest.Filename)
+	case <-time.After(wait):
+	}
+}
+
+func TestWatcherEvents(t *testing.T) {
+	for _, mode := range []string{"poll", "inotify"} {
+		t.Run(mode, func(t *testing.T) {
+			dir := t.TempDir()
+			existing := filepath.Join(dir, "ab", "cd", "abcd0000.json")
+			writeSession(t, existing, "alice")
+
+			wt := NewWatcher(dir)
+			defer wt.Close()
+			if got := wt.Start(mode, 50*time.Millisecond); got != mode {
+				t.Skipf("%s unavailable here, watcher fell back to %s", mode, got)
+			}
+			if snap := wt.Snapshot(); len(snap) != 1 || snap[0].Username != "alice" {
+				t.Fatalf("initial snapshot = %+v", snap)
+			}
+			ch := wt.Subscribe()
+			defer wt.Unsubscribe(ch)
+
+			// a new shard dir exercises the watch added after startup
+			created := filepath.Join(dir, "ef", "01", "ef010000.json")
+			writeSession(t, created, "bob")
+			ev := nextEvent(t, ch, "create")
+			if ev.Digest.Filename != "ef010000.json" || ev.Digest.Username != "bob" || ev.Source != mode {
+				t.Fatalf("create event = %+v", ev)
+			}
+
+			// sliding expiry only moves the mtime
+			future := time.Now().Add(time.Minute)
+			os.Chtimes(existing, future, future)
+			noEvent(t, ch, 300*time.Millisecond)
+
+			writeSession(t, existing, "carol")
+			ev = nextEvent(t, ch, "modify")
+			if ev.Digest.Username != "carol" {
+				t.Fatalf("modify event = %+v", ev)
+			}
+
+			os.Remove(created)
+			ev = nextEvent(t, ch, "delete")
+			if ev.Digest.Filename != "ef010000.json" {
+				t.Fatalf("delete event = %+v", ev)
+			}
+
+			// temp files and dot dirs are not sessions
+			os.WriteFile(filepath.Join(dir, "ab", "cd", ".tmp-x.json"), []byte("{}"), 0644)
+			writeSession(t, filepath.Join(dir, ".index", "alice.json"), "alice")
+			noEvent(t, ch, 300*time.Millisecond)
+
+			if snap := wt.Snapshot(); len(snap) != 1 || snap[0].Username != "carol" {
+				t.Fatalf("final snapshot = %+v", snap)
+			}
+		})
+	}
+}
+
+func TestIndexRedirectsToTrailingSlash(t *testing.T) {
+	rec := httptest.NewRecorder()
+	handleIndex(rec, httptest.NewRequest(http.MethodGet, "/watcher", nil))
+	if rec.Code != http.StatusMovedPermanently || rec.Header().Get("Location") != "/watcher/" {
+		t.Fatalf("GET /watcher = %d to %q, want a redirect to /watcher/", rec.Code, rec.Header().Get("Location"))
+	}
+}
@@ -4,111 +4,189 @@ import (
 	"crypto/rand"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"log"
 	"os"
-	"path/filepath"
 	"strings"
 	"time"
 )
 
+var (
+	sessionBackend       = getEnv("SESSION_STORE", "file")
+	sessionTTL           = getEnvDuration("SESSION_TTL", 24*time.Hour)
+	sessionIdleTTL       = getEnvDuration("SESSION_IDLE_TTL", 30*time.Minute)
+	sessionSweepInterval = getEnvDuration("SESSION_SWEEP_INTERVAL", time.Minute)
+	sessionFsync         = getEnv("SESSION_FSYNC", "false") == "true"
+)
+
+var (
+	// ErrSessionExpired is returned by Get for a session past its absolute or idle TTL.
+	ErrSessionExpired = errors.New("session expired")
+	// ErrVersionConflict is returned by SetData when the session changed since
+	// the version the caller read.
+	ErrVersionConflict = errors.New("session data version conflict")
+)
+
 type Session struct {
 	Username  string `json:"username"`
 	SessionID string `json:"session_id"`
 	CreatedAt string `json:"created_at"`
 	CreatedBy string `json:"created_by"`
+	// synchronizer token state-changing requests must echo back in X-CSRF-Token
+	CSRFToken string `json:"csrf_token,omitempty"`
+	// arbitrary per-session payload, replaced only through SetData
+	Data map[string]json.RawMessage `json:"data,omitempty"`
+	// bumped by every SetData, the basis of the data ETag
+	Version int `json:"version"`
+
+	// derived from the file on read, never persisted
+	LastSeenAt string `json:"last_seen_at,omitempty"`
+	ExpiresAt  string `json:"expires_at,omitempty"`
 }
 
-// hardcoded users for the demo
-var validUsers = map[string]string{
-	"alice": "password123",
-	"bob":   "password456",
+// SessionStore is the storage layout behind the login/me loop. every backend
+// applies the same TTL rules, so they can be swapped with SESSION_STORE and
+// compared under the same multi-instance traffic.
+type SessionStore interface {
+	Create(username, hostname string) (*Session, error)
+	// Get returns the session and slides its idle expiry, or the session
+	// together with ErrSessionExpired once it is past its TTL.
+	Get(sessionID string) (*Session, error)
+	// Peek is Get without sliding the idle expiry, for callers acting on a
+	// session rather than on behalf of its owner.
+	Peek(sessionID string) (*Session, error)
+	Delete(sessionID string) error
+	// SetData replaces the session's data if its version is still version,
+	// bumping it. on a mismatch it returns the current session with ErrVersionConflict.
+	SetData(sessionID string, version int, data map[string]json.RawMessage) (*Session, error)
+	// List returns up to limit sessions (all when limit <= 0) with IDs after
+	// cursor in ID order, plus the cursor for the next page, "" when done.
+	List(cursor string, limit int) ([]Session, string, error)
+	// ListByUser returns every live session belonging to username.
+	ListByUser(username string) ([]Session, error)
+	// Sweep removes expired sessions. it returns nil, nil when another
+	// instance holds the sweep or swept within the last interval.
+	Sweep(holder string, interval time.Duration) (*SweepStatus, error)
 }
 
-type SessionStore struct {
-	dir string
+// newSessionStore builds the backend named by SESSION_STORE.
+func newSessionStore(backend, dir string, ttl, idleTTL time.Duration, fsync bool) (SessionStore, error) {
+	switch backend {
+	case "file":
+		return NewFileSessionStore(dir, ttl, idleTTL, fsync), nil
+	case "memory":
+		return NewMemorySessionStore(ttl, idleTTL), nil
+	case "log":
+		return NewLogSessionStore(dir, ttl, idleTTL, fsync), nil
+	default:
+		return nil, fmt.Errorf("unknown session store %q (want file, memory or log)", backend)
+	}
 }
 
-func NewSessionStore(dir string) *SessionStore {
-	os.MkdirAll(dir, 0755)
-	// gvisor gofer ignores mode on mkdir over NFS, force correct perms
-	os.Chmod(dir, 0755)
-	return &SessionStore{dir: dir}
+// expiryPolicy holds the TTL rules shared by every backend.
+type expiryPolicy struct {
+	ttl     time.Duration
+	idleTTL time.Duration
 }
 
-func (s *SessionStore) Create(username, hostname string) (*Session, error) {
-	id, err := generateSessionID()
-	if err != nil {
-		return nil, fmt.Errorf("generate session id: %w", err)
-	}
-
-	sess := &Session{
-		Username:  username,
-		SessionID: id,
-		CreatedAt: time.Now().UTC().Format(time.RFC3339),
-		CreatedBy: hostname,
+// expiresAt is the earlier of the absolute and idle deadlines.
+func (p expiryPolicy) expiresAt(sess *Session, lastSeen time.Time) time.Time {
+	deadline := lastSeen.Add(p.idleTTL)
+	if created, err := time.Parse(time.RFC3339, sess.CreatedAt); err == nil {
+		if abs := created.Add(p.ttl); abs.Before(deadline) {
+			deadline = abs
+		}
 	}
+	return deadline
+}
 
-	data, err := json.MarshalIndent(sess, "", "  ")
-	if err != nil {
-		return nil, fmt.Errorf("marshal session: %w", err)
-	}
+func (p expiryPolicy) expired(sess *Session, lastSeen, now time.Time) bool {
+	return now.After(p.expiresAt(sess, lastSeen))
+}
 
-	path := filepath.Join(s.dir, id+".json")
-	if err := os.WriteFile(path, data, 0644); err != nil {
-		return nil, fmt.Errorf("write session file: %w", err)
-	}
+// shouldTouch reports whether Get should persist a new last-seen time. only
+// once a meaningful fraction of the idle window has passed, so a burst of
+// requests doesn't turn into a burst of writes.
+func (p expiryPolicy) shouldTouch(lastSeen, now time.Time) bool {
+	return now.Sub(lastSeen) > p.idleTTL/10
+}
 
-	return sess, nil
+func (p expiryPolicy) annotate(sess *Session, lastSeen time.Time) {
+	sess.LastSeenAt = lastSeen.UTC().Format(time.RFC3339)
+	sess.ExpiresAt = p.expiresAt(sess, lastSeen).UTC().Format(time.RFC3339)
 }
 
-func (s *SessionStore) Get(sessionID string) (*Session, error) {
-	// prevent directory traversal
-	if strings.Contains(sessionID, "/") || strings.Contains(sessionID, "..") {
-		return nil, fmt.Errorf("invalid session id")
+// validSessionID rejects IDs that could escape the session directory or are
+// too short to shard.
+func validSessionID(sessionID string) error {
+	if len(sessionID) < 4 || strings.Contains(sessionID, "/") || strings.Contains(sessionID, "..") {
+		return fmt.Errorf("invalid session id")
 	}
+	return nil
+}
+
+// SweepStatus is what the last sweeper run recorded on the mount, visible to every instance.
+type SweepStatus struct {
+	Holder   string `json:"holder"`
+	SweptAt  string `json:"swept_at"`
+	Scanned  int    `json:"scanned"`
+	Deleted  int    `json:"deleted"`
+	Duration string `json:"duration"`
+}
 
-	path := filepath.Join(s.dir, sessionID+".json")
-	data, err := os.ReadFile(path)
+// sweptWithin reports whether the status file records a sweep less than interval ago.
+func sweptWithin(statusPath string, interval time.Duration) bool {
+	data, err := os.ReadFile(statusPath)
 	if err != nil {
-		return nil, err
+		return false
 	}
-
-	var sess Session
-	if err := json.Unmarshal(data, &sess); err != nil {
-		return nil, fmt.Errorf("unmarshal session: %w", err)
+	var last SweepStatus
+	if json.Unmarshal(data, &last) != nil {
+		return false
 	}
-	return &sess, nil
+	t, err := time.Parse(time.RFC3339Nano, last.SweptAt)
+	return err == nil && time.Since(t) < interval
 }
 
-func (s *SessionStore) Delete(sessionID string) error {
-	if strings.Contains(sessionID, "/") || strings.Contains(sessionID, "..") {
-		return fmt.Errorf("invalid session id")
-	}
-	return os.Remove(filepath.Join(s.dir, sessionID+".json"))
+// StartSweeper runs store.Sweep every interval in the background.
+func StartSweeper(store SessionStore, holder string, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			status, err := store.Sweep(holder, interval)
+			switch {
+			case err != nil:
+				log.Printf("session sweep: %v", err)
+			case status != nil && status.Deleted > 0:
+				log.Printf("session sweep: deleted %d/%d expired sessions in %s", status.Deleted, status.Scanned, status.Duration)
+			}
+			// whoever swept also clears tombstones whose cookies can no longer be valid
+			if status != nil && revocations != nil {
+				revocations.Prune(sessionTTL)
+			}
+		}
+	}()
 }
 
-func (s *SessionStore) List() ([]Session, error) {
-	entries, err := os.ReadDir(s.dir)
+// newSession builds a fresh session with its ID and CSRF token for a backend to persist.
+func newSession(username, hostname string) (*Session, error) {
+	id, err := generateSessionID()
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("generate session id: %w", err)
 	}
-
-	var sessions []Session
-	for _, e := range entries {
-		if !strings.HasSuffix(e.Name(), ".json") {
-			continue
-		}
-		data, err := os.ReadFile(filepath.Join(s.dir, e.Name()))
-		if err != nil {
-			continue
-		}
-		var sess Session
-		if err := json.Unmarshal(data, &sess); err != nil {
-			continue
-		}
-		sessions = append(sessions, sess)
+	token, err := generateCSRFToken()
+	if err != nil {
+		return nil, fmt.Errorf("generate csrf token: %w", err)
 	}
-	return sessions, nil
+	return &Session{
+		Username:  username,
+		SessionID: id,
+		CreatedAt: time.Now().UTC().Format(time.RFC3339),
+		CreatedBy: hostname,
+		CSRFToken: token,
+	}, nil
 }
 
 func generateSessionID() (string, error) {
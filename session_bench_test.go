@@ -0,0 +1,106 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"os"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// the sharded store is populated once per `go test -bench` run, by default with
+// 100k sessions; set SESSION_BENCH_N to change that and NFS_PATH to run on a mount:
+//
+//	NFS_PATH=/mnt/nfs go test -run '^$' -bench FileSession
+var (
+	benchOnce  sync.Once
+	benchStore *FileSessionStore
+	benchIDs   []string
+	benchErr   error
+)
+
+func sessionBenchStore(b *testing.B) (*FileSessionStore, []string) {
+	b.Helper()
+	benchOnce.Do(func() {
+		n := getEnvInt("SESSION_BENCH_N", 100000)
+		base := os.Getenv("NFS_PATH")
+		if base == "" {
+			base = os.TempDir()
+		}
+		dir, err := os.MkdirTemp(base, "session-bench-")
+		if err != nil {
+			benchErr = err
+			return
+		}
+		benchStore = NewFileSessionStore(dir, time.Hour, time.Hour, false)
+		benchIDs = make([]string, n)
+
+		start := time.Now()
+		var mu sync.Mutex
+		benchErr = parallelEach(n, func(i int) error {
+			sess, err := benchStore.Create("bench"+strconv.Itoa(i%100), "bench-host")
+			if err != nil {
+				return err
+			}
+			mu.Lock()
+			benchIDs[i] = sess.SessionID
+			mu.Unlock()
+			return nil
+		})
+		fmt.Printf("created %d sessions in %s under %s\n", n, time.Since(start).Round(time.Millisecond), dir)
+	})
+	if benchErr != nil {
+		b.Fatal(benchErr)
+	}
+	return benchStore, benchIDs
+}
+
+func BenchmarkFileSessionGet(b *testing.B) {
+	store, ids := sessionBenchStore(b)
+	r := rand.New(rand.NewSource(1))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := store.Get(ids[r.Intn(len(ids))]); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkFileSessionListPage(b *testing.B) {
+	store, ids := sessionBenchStore(b)
+	r := rand.New(rand.NewSource(1))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		// start each page at a random position, as a client deep into paging would
+		page, _, err := store.List(ids[r.Intn(len(ids))], defaultSessionPage)
+		if err != nil {
+			b.Fatal(err)
+		}
+		if len(page) == 0 {
+			b.Fatal("empty page")
+		}
+	}
+}
+
+func BenchmarkFileSessionListAll(b *testing.B) {
+	store, ids := sessionBenchStore(b)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		list, _, err := store.List("", 0)
+		if err != nil {
+			b.Fatal(err)
+		}
+		if len(list) != len(ids) {
+			b.Fatalf("listed %d of %d", len(list), len(ids))
+		}
+	}
+}
+
+// cleanupSessionBench removes the benchmark sessions once all benchmarks ran.
+func cleanupSessionBench() {
+	if benchStore != nil {
+		os.RemoveAll(benchStore.dir)
+	}
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const maxSessionData = 64 << 10 // 64KB
+
+func sessionETag(version int) string {
+	return fmt.Sprintf(`"v%d"`, version)
+}
+
+// parseETag turns an If-Match value back into a version. ok is false when the
+// header is absent; a malformed one is an error.
+func parseETag(header string) (version int, ok bool, err error) {
+	if header == "" {
+		return 0, false, nil
+	}
+	tag := strings.TrimPrefix(strings.TrimSpace(header), "W/")
+	tag = strings.TrimSuffix(strings.TrimPrefix(tag, `"v`), `"`)
+	version, err = strconv.Atoi(tag)
+	if err != nil {
+		return 0, false, fmt.Errorf("invalid If-Match %q", header)
+	}
+	return version, true, nil
+}
+
+func writeSessionData(w http.ResponseWriter, sess *Session) {
+	data := sess.Data
+	if data == nil {
+		data = map[string]json.RawMessage{}
+	}
+	w.Header().Set("ETag", sessionETag(sess.Version))
+	writeJSON(w, map[string]interface{}{
+		"data":      data,
+		"version":   sess.Version,
+		"served_by": hostname,
+	})
+}
+
+// handleSessionData serves the current session's key/value payload:
+//
+//	GET   returns {data, version} with an ETag
+//	PUT   replaces data with the request body object
+//	PATCH merges the body into data, a null value deletes its key
+//
+// writes are checked against If-Match, or against the version this instance
+// just read when it is absent. a write that lost the race answers 409 with the
+// current data and version, so clients retry instead of silently overwriting.
+func handleSessionData(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodPut && r.Method != http.MethodPatch {
+		http.Error(w, "GET, PUT or PATCH only", http.StatusMethodNotAllowed)
+		return
+	}
+	sess := requireSession(w, r)
+	if sess == nil {
+		return
+	}
+	if r.Method == http.MethodGet {
+		writeSessionData(w, sess)
+		return
+	}
+
+	version, pinned, err := parseETag(r.Header.Get("If-Match"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		writeJSON(w, map[string]string{"error": err.Error()})
+		return
+	}
+	if !pinned {
+		version = sess.Version
+	}
+	if version != sess.Version {
+		writeDataConflict(w, sess, version)
+		return
+	}
+
+	var body map[string]json.RawMessage
+	r.Body = http.MaxBytesReader(w, r.Body, maxSessionData)
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		writeJSON(w, map[string]string{"error": "body must be a JSON object (max 64KB)"})
+		return
+	}
+
+	data := body
+	if r.Method == http.MethodPatch {
+		data = make(map[string]json.RawMessage, len(sess.Data)+len(body))
+		for k, v := range sess.Data {
+			data[k] = v
+		}
+		for k, v := range body {
+			if string(v) == "null" {
+				delete(data, k)
+			} else {
+				data[k] = v
+			}
+		}
+	}
+
+	updated, err := sessions.SetData(sess.SessionID, version, data)
+	if errors.Is(err, ErrVersionConflict) {
+		writeDataConflict(w, updated, version)
+		return
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		writeJSON(w, map[string]string{"error": err.Error()})
+		return
+	}
+	writeSessionData(w, updated)
+}
+
+func writeDataConflict(w http.ResponseWriter, current *Session, tried int) {
+	w.Header().Set("ETag", sessionETag(current.Version))
+	w.WriteHeader(http.StatusConflict)
+	writeJSON(w, map[string]interface{}{
+		"error":           ErrVersionConflict.Error(),
+		"tried_version":   tried,
+		"current_version": current.Version,
+		"data":            current.Data,
+		"served_by":       hostname,
+	})
+}
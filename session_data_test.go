@@ -0,0 +1,183 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestSessionDataNoLostUpdates has two instances of each shared backend bump a
+// counter in the same session with read-modify-write loops. conflicts are
+// expected and retried; a final count below the number of increments would be
+// a lost update.
+func TestSessionDataNoLostUpdates(t *testing.T) {
+	for _, backend := range []string{"memory", "file", "log"} {
+		t.Run(backend, func(t *testing.T) {
+			dir := t.TempDir()
+			a, _ := newSessionStore(backend, dir, time.Hour, time.Hour, false)
+			b := a
+			if backend != "memory" {
+				b, _ = newSessionStore(backend, dir, time.Hour, time.Hour, false)
+			}
+			sess, err := a.Create("alice", "host-a")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			const workers, increments = 8, 25
+			var wg sync.WaitGroup
+			var mu sync.Mutex
+			conflicts := 0
+			for w := 0; w < workers; w++ {
+				wg.Add(1)
+				go func(store SessionStore) {
+					defer wg.Done()
+					for i := 0; i < increments; {
+						cur, err := store.Get(sess.SessionID)
+						if err != nil {
+							t.Errorf("get: %v", err)
+							return
+						}
+						n, _ := strconv.Atoi(string(cur.Data["count"]))
+						data := map[string]json.RawMessage{"count": json.RawMessage(strconv.Itoa(n + 1))}
+						_, err = store.SetData(sess.SessionID, cur.Version, data)
+						if errors.Is(err, ErrVersionConflict) {
+							mu.Lock()
+							conflicts++
+							mu.Unlock()
+							continue
+						}
+						if err != nil {
+							t.Errorf("set: %v", err)
+							return
+						}
+						i++
+					}
+				}([]SessionStore{a, b}[w%2])
+			}
+			wg.Wait()
+
+			final, _ := b.Get(sess.SessionID)
+			t.Logf("%d increments, %d conflicts retried, version %d", workers*increments, conflicts, final.Version)
+			if got := string(final.Data["count"]); got != strconv.Itoa(workers*increments) {
+				t.Fatalf("count = %s, want %d", got, workers*increments)
+			}
+			if final.Version != workers*increments {
+				t.Fatalf("version = %d, want %d", final.Version, workers*increments)
+			}
+		})
+	}
+}
+
+// TestSessionDataDeleteNotRevived deletes sessions while another instance keeps
+// writing their data. a write racing the delete must not bring the session back.
+func TestSessionDataDeleteNotRevived(t *testing.T) {
+	for _, backend := range []string{"memory", "file", "log"} {
+		t.Run(backend, func(t *testing.T) {
+			dir := t.TempDir()
+			a, _ := newSessionStore(backend, dir, time.Hour, time.Hour, false)
+			b := a
+			if backend != "memory" {
+				b, _ = newSessionStore(backend, dir, time.Hour, time.Hour, false)
+			}
+			for round := 0; round < 50; round++ {
+				sess, err := a.Create("alice", "host-a")
+				if err != nil {
+					t.Fatal(err)
+				}
+				data := map[string]json.RawMessage{"k": json.RawMessage(`"v"`)}
+				cur, err := b.SetData(sess.SessionID, 0, data)
+				if err != nil {
+					t.Fatal(err)
+				}
+				started, done := make(chan struct{}), make(chan struct{})
+				var once sync.Once
+				go func(version int) {
+					defer close(done)
+					defer once.Do(func() { close(started) })
+					for i := 0; i < 200; i++ {
+						if i == 3 {
+							once.Do(func() { close(started) })
+						}
+						cur, err := b.SetData(sess.SessionID, version, data)
+						if errors.Is(err, ErrVersionConflict) {
+							version = cur.Version
+							continue
+						}
+						if err != nil {
+							return
+						}
+						version = cur.Version
+					}
+				}(cur.Version)
+				<-started
+				if err := a.Delete(sess.SessionID); err != nil {
+					t.Fatalf("delete: %v", err)
+				}
+				<-done
+				if got, err := b.Get(sess.SessionID); err == nil {
+					t.Fatalf("round %d: session revived after delete at version %d", round, got.Version)
+				}
+			}
+		})
+	}
+}
+
+func TestSessionDataHandler(t *testing.T) {
+	var err error
+	prevSessions, prevKeys := sessions, cookieKeys
+	t.Cleanup(func() { sessions, cookieKeys = prevSessions, prevKeys })
+	sessions = NewMemorySessionStore(time.Hour, time.Hour)
+	if cookieKeys, err = NewCookieKeyring(filepath.Join(t.TempDir(), "keys.json"), time.Hour, 3); err != nil {
+		t.Fatal(err)
+	}
+	sess, _ := sessions.Create("alice", "test-host")
+	cookie, _ := cookieKeys.Sign(sess.SessionID)
+
+	do := func(method, ifMatch, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/api/v1/session/data", strings.NewReader(body))
+		req.AddCookie(&http.Cookie{Name: sessionCookie, Value: cookie})
+		req.Header.Set(csrfHeader, sess.CSRFToken)
+		if ifMatch != "" {
+			req.Header.Set("If-Match", ifMatch)
+		}
+		rec := httptest.NewRecorder()
+		handleSessionData(rec, req)
+		return rec
+	}
+
+	rec := do(http.MethodPut, `"v0"`, `{"theme":"dark","cart":[1,2]}`)
+	if rec.Code != http.StatusOK || rec.Header().Get("ETag") != `"v1"` {
+		t.Fatalf("put: %d etag=%s %s", rec.Code, rec.Header().Get("ETag"), rec.Body)
+	}
+	rec = do(http.MethodPatch, `"v1"`, `{"cart":null,"lang":"en"}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("patch: %d %s", rec.Code, rec.Body)
+	}
+	var got struct {
+		Data    map[string]json.RawMessage `json:"data"`
+		Version int                        `json:"version"`
+	}
+	json.Unmarshal(rec.Body.Bytes(), &got)
+	if got.Version != 2 || string(got.Data["theme"]) != `"dark"` || string(got.Data["lang"]) != `"en"` || got.Data["cart"] != nil {
+		t.Fatalf("after patch: %+v", got)
+	}
+
+	// a writer still holding v1 lost the race
+	if rec := do(http.MethodPut, `"v1"`, `{"theme":"light"}`); rec.Code != http.StatusConflict || rec.Header().Get("ETag") != `"v2"` {
+		t.Fatalf("stale put: %d etag=%s", rec.Code, rec.Header().Get("ETag"))
+	}
+	if rec := do(http.MethodPut, `garbage`, `{}`); rec.Code != http.StatusBadRequest {
+		t.Fatalf("bad If-Match: %d", rec.Code)
+	}
+	if rec := do(http.MethodPatch, "", `[1]`); rec.Code != http.StatusBadRequest {
+		t.Fatalf("non-object body: %d", rec.Code)
+	}
+}
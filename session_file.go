@@ -0,0 +1,638 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// FileSessionStore keeps one JSON file per session, sharded by ID prefix as
+// dir/ab/cd/<id>.json so no directory grows past a few entries per 65536 sessions.
+// files are written to a dot-prefixed temp name and renamed into place, so other
+// instances and the session-watcher never see a half-written session.
+// the file's mtime doubles as the last-seen time: Get bumps it with a SETATTR
+// instead of rewriting the JSON, so sliding expiry costs no extra data writes.
+type FileSessionStore struct {
+	expiryPolicy
+	dir   string
+	fsync bool
+}
+
+func NewFileSessionStore(dir string, ttl, idleTTL time.Duration, fsync bool) *FileSessionStore {
+	os.MkdirAll(dir, 0755)
+	// gvisor gofer ignores mode on mkdir over NFS, force correct perms
+	os.Chmod(dir, 0755)
+	return &FileSessionStore{expiryPolicy: expiryPolicy{ttl: ttl, idleTTL: idleTTL}, dir: dir, fsync: fsync}
+}
+
+func (s *FileSessionStore) Create(username, hostname string) (*Session, error) {
+	sess, err := newSession(username, hostname)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := json.MarshalIndent(sess, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("marshal session: %w", err)
+	}
+
+	path := s.shardPath(sess.SessionID)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("create shard dir: %w", err)
+	}
+	if err := writeFileAtomic(path, data, 0644, s.fsync); err != nil {
+		return nil, fmt.Errorf("write session file: %w", err)
+	}
+	if err := s.index(sess.Username, sess.SessionID); err != nil {
+		// a session missing from the index would survive a revoke-all
+		os.Remove(path)
+		return nil, err
+	}
+
+	s.annotate(sess, time.Now())
+	return sess, nil
+}
+
+func (s *FileSessionStore) Get(sessionID string) (*Session, error) {
+	// prevent directory traversal
+	if err := validSessionID(sessionID); err != nil {
+		return nil, err
+	}
+
+	path, sess, lastSeen, err := s.load(sessionID)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	if s.expired(sess, lastSeen, now) {
+		s.annotate(sess, lastSeen)
+		return sess, ErrSessionExpired
+	}
+
+	if s.shouldTouch(lastSeen, now) {
+		if err := os.Chtimes(path, now, now); err == nil {
+			lastSeen = now
+		}
+	}
+	s.annotate(sess, lastSeen)
+	return sess, nil
+}
+
+func (s *FileSessionStore) Peek(sessionID string) (*Session, error) {
+	if err := validSessionID(sessionID); err != nil {
+		return nil, err
+	}
+	_, sess, lastSeen, err := s.load(sessionID)
+	if err != nil {
+		return nil, err
+	}
+	s.annotate(sess, lastSeen)
+	if s.expired(sess, lastSeen, time.Now()) {
+		return sess, ErrSessionExpired
+	}
+	return sess, nil
+}
+
+// Delete removes the session under the data lock, so an in-flight SetData on
+// another instance can't rename its rewrite over the deleted file and revive it.
+func (s *FileSessionStore) Delete(sessionID string) error {
+	if err := validSessionID(sessionID); err != nil {
+		return err
+	}
+	unlock, err := s.lockData(sessionID)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	err = os.Remove(s.shardPath(sessionID))
+	if os.IsNotExist(err) {
+		err = os.Remove(s.flatPath(sessionID))
+	}
+	return err
+}
+
+const sessionDataLockFile = ".lock"
+
+// lockData takes the lock held by anything that rewrites, moves or removes an
+// existing session file. it is one lock file per shard dir, shared by the flat
+// file of a not yet migrated session, so instances only wait on sessions in the
+// same shard. the session file itself can't carry the lock: every rewrite
+// renames a new inode over it.
+func (s *FileSessionStore) lockData(id string) (func(), error) {
+	shard := filepath.Dir(s.shardPath(id))
+	if err := os.MkdirAll(shard, 0755); err != nil {
+		return nil, fmt.Errorf("create shard dir: %w", err)
+	}
+	unlock, _, err := lockPath(filepath.Join(shard, sessionDataLockFile), true)
+	if err != nil {
+		return nil, fmt.Errorf("lock session data: %w", err)
+	}
+	return unlock, nil
+}
+
+// SetData rewrites the session file under the data lock, so the version check
+// and the write are one step across instances, and a Delete can't slip in
+// between them. taking the lock also makes the NFS client revalidate, so the
+// re-read under it sees other instances' writes and deletes.
+func (s *FileSessionStore) SetData(sessionID string, version int, data map[string]json.RawMessage) (*Session, error) {
+	if err := validSessionID(sessionID); err != nil {
+		return nil, err
+	}
+	unlock, err := s.lockData(sessionID)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	path, sess, lastSeen, err := s.load(sessionID)
+	if err != nil {
+		return nil, err
+	}
+	if sess.Version != version {
+		s.annotate(sess, lastSeen)
+		return sess, ErrVersionConflict
+	}
+	sess.Data = data
+	sess.Version++
+
+	out, err := json.MarshalIndent(sess, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("marshal session: %w", err)
+	}
+	if err := writeFileAtomic(path, out, 0644, s.fsync); err != nil {
+		return nil, fmt.Errorf("write session file: %w", err)
+	}
+	// the rename gives the file a fresh mtime, so the write also counts as activity
+	s.annotate(sess, time.Now())
+	return sess, nil
+}
+
+const (
+	userIndexDir   = ".index"
+	userIndexLock  = ".lock"
+	userIndexBuilt = ".built"
+)
+
+// the per-user index is one file per username under dir/.index listing that
+// user's session IDs, one per line. creates append under a lock; Delete and the
+// sweeper leave entries behind, and ListByUser prunes the dead ones it finds.
+// sessions from before the index existed are added by buildIndex on first use.
+func (s *FileSessionStore) indexPath(username string) (string, error) {
+	if !usernamePattern.MatchString(username) {
+		return "", fmt.Errorf("invalid username %q", username)
+	}
+	return filepath.Join(s.dir, userIndexDir, username), nil
+}
+
+func (s *FileSessionStore) lockIndex() (func(), error) {
+	dir := filepath.Join(s.dir, userIndexDir)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("create index dir: %w", err)
+	}
+	unlock, _, err := lockPath(filepath.Join(dir, userIndexLock), true)
+	if err != nil {
+		return nil, fmt.Errorf("lock session index: %w", err)
+	}
+	return unlock, nil
+}
+
+func (s *FileSessionStore) index(username, sessionID string) error {
+	path, err := s.indexPath(username)
+	if err != nil {
+		return err
+	}
+	unlock, err := s.lockIndex()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("open session index: %w", err)
+	}
+	if _, err := f.WriteString(sessionID + "\n"); err != nil {
+		f.Close()
+		return fmt.Errorf("append session index: %w", err)
+	}
+	if s.fsync {
+		if err := f.Sync(); err != nil {
+			f.Close()
+			return fmt.Errorf("fsync session index: %w", err)
+		}
+	}
+	return f.Close()
+}
+
+// buildIndex adds every existing session, sharded or flat, to the index once
+// per session dir, leaving a marker so later calls cost a single stat. it runs
+// under the index lock, so creates meanwhile append after it and nothing is lost.
+func (s *FileSessionStore) buildIndex() error {
+	marker := filepath.Join(s.dir, userIndexDir, userIndexBuilt)
+	if _, err := os.Stat(marker); err == nil {
+		return nil
+	}
+	unlock, err := s.lockIndex()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	if _, err := os.Stat(marker); err == nil {
+		// another instance built it while we waited
+		return nil
+	}
+
+	byUser := map[string][]string{}
+	collect := func(id, path string) error {
+		if sess, _, err := s.read(path); err == nil && usernamePattern.MatchString(sess.Username) {
+			byUser[sess.Username] = append(byUser[sess.Username], id)
+		}
+		return nil
+	}
+	if err := s.walk("", collect); err != nil {
+		return fmt.Errorf("build session index: %w", err)
+	}
+	if err := s.walkFlat(collect); err != nil {
+		return fmt.Errorf("build session index: %w", err)
+	}
+
+	for username, ids := range byUser {
+		path := filepath.Join(s.dir, userIndexDir, username)
+		indexed, err := indexedIDs(path)
+		if err != nil {
+			return fmt.Errorf("read session index: %w", err)
+		}
+		seen := map[string]bool{}
+		var out strings.Builder
+		for _, id := range append(indexed, ids...) {
+			if !seen[id] {
+				seen[id] = true
+				out.WriteString(id + "\n")
+			}
+		}
+		if err := writeFileAtomic(path, []byte(out.String()), 0644, s.fsync); err != nil {
+			return fmt.Errorf("write session index: %w", err)
+		}
+	}
+	return writeFileAtomic(marker, []byte(time.Now().UTC().Format(time.RFC3339Nano)+"\n"), 0644, s.fsync)
+}
+
+// indexedIDs reads a user's index, deduplicated, in order.
+func indexedIDs(path string) ([]string, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	seen := map[string]bool{}
+	var ids []string
+	for _, id := range strings.Split(string(data), "\n") {
+		if id != "" && !seen[id] {
+			seen[id] = true
+			ids = append(ids, id)
+		}
+	}
+	return ids, nil
+}
+
+func (s *FileSessionStore) ListByUser(username string) ([]Session, error) {
+	path, err := s.indexPath(username)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.buildIndex(); err != nil {
+		return nil, err
+	}
+	ids, err := indexedIDs(path)
+	if err != nil {
+		return nil, fmt.Errorf("read session index: %w", err)
+	}
+
+	var sessions []Session
+	stale := 0
+	for _, id := range ids {
+		if validSessionID(id) != nil {
+			stale++
+			continue
+		}
+		_, sess, lastSeen, err := s.load(id)
+		if err != nil {
+			if os.IsNotExist(err) {
+				stale++
+			}
+			continue
+		}
+		s.annotate(sess, lastSeen)
+		sessions = append(sessions, *sess)
+	}
+	if stale > 0 {
+		s.pruneIndex(path)
+	}
+	return sessions, nil
+}
+
+// pruneIndex rewrites a user's index without the IDs whose files are gone. it
+// re-reads under the lock so IDs appended meanwhile by other instances survive.
+func (s *FileSessionStore) pruneIndex(path string) {
+	unlock, err := s.lockIndex()
+	if err != nil {
+		return
+	}
+	defer unlock()
+	ids, err := indexedIDs(path)
+	if err != nil {
+		return
+	}
+	var live strings.Builder
+	for _, id := range ids {
+		if validSessionID(id) != nil {
+			continue
+		}
+		if _, err := os.Stat(s.shardPath(id)); err == nil {
+			live.WriteString(id + "\n")
+		} else if _, err := os.Stat(s.flatPath(id)); err == nil {
+			live.WriteString(id + "\n")
+		}
+	}
+	writeFileAtomic(path, []byte(live.String()), 0644, s.fsync)
+}
+
+// List returns up to limit sessions with IDs after cursor, in ID order, and the
+// cursor for the next page ("" once done). shards wholly before the cursor are
+// skipped without being read, so a page costs a few READDIRs however many
+// sessions exist. flat-layout files awaiting migration are not listed.
+func (s *FileSessionStore) List(cursor string, limit int) ([]Session, string, error) {
+	var sessions []Session
+	last, next := "", ""
+	err := s.walk(cursor, func(id, path string) error {
+		if limit > 0 && len(sessions) == limit {
+			// a full page only gets a cursor if another session follows it
+			next = last
+			return errStopWalk
+		}
+		sess, lastSeen, err := s.read(path)
+		if err != nil {
+			return nil
+		}
+		s.annotate(sess, lastSeen)
+		sessions = append(sessions, *sess)
+		last = id
+		return nil
+	})
+	if err != nil && !errors.Is(err, errStopWalk) {
+		return nil, "", err
+	}
+	return sessions, next, nil
+}
+
+var errStopWalk = errors.New("stop walk")
+
+// walk calls fn for every sharded session file with an ID after cursor, in ID order.
+func (s *FileSessionStore) walk(cursor string, fn func(id, path string) error) error {
+	top, err := shardNames(s.dir)
+	if err != nil {
+		return err
+	}
+	for _, a := range top {
+		if a < prefix(cursor, 2) {
+			continue
+		}
+		mid, err := shardNames(filepath.Join(s.dir, a))
+		if err != nil {
+			continue
+		}
+		for _, b := range mid {
+			if a+b < prefix(cursor, 4) {
+				continue
+			}
+			shard := filepath.Join(s.dir, a, b)
+			entries, err := os.ReadDir(shard)
+			if err != nil {
+				continue
+			}
+			for _, e := range entries {
+				if !strings.HasSuffix(e.Name(), ".json") || strings.HasPrefix(e.Name(), ".") {
+					continue
+				}
+				id := strings.TrimSuffix(e.Name(), ".json")
+				if id <= cursor {
+					continue
+				}
+				if err := fn(id, filepath.Join(shard, e.Name())); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// walkFlat calls fn for every session file still in the pre-sharding layout.
+func (s *FileSessionStore) walkFlat(fn func(id, path string) error) error {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || !strings.HasSuffix(name, ".json") || strings.HasPrefix(name, ".") {
+			continue
+		}
+		id := strings.TrimSuffix(name, ".json")
+		if validSessionID(id) != nil {
+			continue
+		}
+		if err := fn(id, s.flatPath(id)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// shardNames lists the two-character shard directories in dir, sorted.
+func shardNames(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, e := range entries {
+		if e.IsDir() && len(e.Name()) == 2 && !strings.HasPrefix(e.Name(), ".") {
+			names = append(names, e.Name())
+		}
+	}
+	return names, nil
+}
+
+func prefix(s string, n int) string {
+	if len(s) < n {
+		return s
+	}
+	return s[:n]
+}
+
+func (s *FileSessionStore) shardPath(id string) string {
+	return filepath.Join(s.dir, id[:2], id[2:4], id+".json")
+}
+
+// flatPath is where the pre-sharding layout kept a session.
+func (s *FileSessionStore) flatPath(id string) string {
+	return filepath.Join(s.dir, id+".json")
+}
+
+// load reads a session from its shard, falling back to the flat layout so
+// sessions created before the upgrade keep working until they are migrated.
+func (s *FileSessionStore) load(id string) (string, *Session, time.Time, error) {
+	path := s.shardPath(id)
+	sess, lastSeen, err := s.read(path)
+	if os.IsNotExist(err) {
+		path = s.flatPath(id)
+		sess, lastSeen, err = s.read(path)
+	}
+	return path, sess, lastSeen, err
+}
+
+// Migrate moves flat-layout session files into their shards. each move is a
+// rename within the mount, so the inode and with it the last-seen mtime survive,
+// and several instances migrating at once just skip each other's files.
+func (s *FileSessionStore) Migrate(dryRun bool) (moved, skipped int, err error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return 0, 0, err
+	}
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || !strings.HasSuffix(name, ".json") || strings.HasPrefix(name, ".") {
+			continue
+		}
+		id := strings.TrimSuffix(name, ".json")
+		if validSessionID(id) != nil {
+			skipped++
+			continue
+		}
+		if dryRun {
+			moved++
+			continue
+		}
+		ok, err := s.migrateOne(id)
+		if err != nil {
+			return moved, skipped, fmt.Errorf("move %s: %w", name, err)
+		}
+		if ok {
+			moved++
+		} else {
+			skipped++
+		}
+	}
+	return moved, skipped, nil
+}
+
+// migrateOne moves one flat file into its shard under the data lock, so a
+// SetData that loaded the flat file can't write it back after the move.
+func (s *FileSessionStore) migrateOne(id string) (bool, error) {
+	unlock, err := s.lockData(id)
+	if err != nil {
+		return false, err
+	}
+	defer unlock()
+	if err := os.Rename(s.flatPath(id), s.shardPath(id)); err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+// read parses a session file and returns it with the file's mtime as last-seen time.
+func (s *FileSessionStore) read(path string) (*Session, time.Time, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	var sess Session
+	if err := json.NewDecoder(f).Decode(&sess); err != nil {
+		return nil, time.Time{}, fmt.Errorf("unmarshal session: %w", err)
+	}
+	return &sess, info.ModTime(), nil
+}
+
+const (
+	sweepLockFile   = ".sweeper.lock"
+	sweepStatusFile = ".sweeper-status"
+)
+
+// Sweep deletes expired session files. instances coordinate through a lock file
+// in the session dir, so only one sweeps at a time; a run is skipped entirely if
+// another instance swept within the last interval.
+func (s *FileSessionStore) Sweep(holder string, interval time.Duration) (*SweepStatus, error) {
+	unlock, locked, err := lockPath(filepath.Join(s.dir, sweepLockFile), false)
+	if err != nil {
+		return nil, fmt.Errorf("lock sweep: %w", err)
+	}
+	if !locked {
+		return nil, nil
+	}
+	defer unlock()
+
+	statusPath := filepath.Join(s.dir, sweepStatusFile)
+	if sweptWithin(statusPath, interval) {
+		return nil, nil
+	}
+
+	start := time.Now()
+	status := &SweepStatus{Holder: holder}
+	sweep := func(id, path string) error {
+		status.Scanned++
+		sess, lastSeen, err := s.read(path)
+		if err != nil || !s.expired(sess, lastSeen, start) {
+			return nil
+		}
+		if s.removeExpired(id, path, start) {
+			status.Deleted++
+		}
+		return nil
+	}
+	if err := s.walk("", sweep); err != nil {
+		return nil, err
+	}
+	// flat-layout files expire like any other, migrated or not
+	if err := s.walkFlat(sweep); err != nil {
+		return nil, err
+	}
+	status.SweptAt = time.Now().UTC().Format(time.RFC3339Nano)
+	status.Duration = time.Since(start).String()
+
+	data, _ := json.MarshalIndent(status, "", "  ")
+	if err := writeFileAtomic(statusPath, data, 0644, s.fsync); err != nil {
+		return status, fmt.Errorf("write sweep status: %w", err)
+	}
+	return status, nil
+}
+
+// removeExpired deletes an expired session file under the data lock, re-reading
+// it there first: a SetData that landed since the sweep read it counts as activity.
+func (s *FileSessionStore) removeExpired(id, path string, now time.Time) bool {
+	unlock, err := s.lockData(id)
+	if err != nil {
+		return false
+	}
+	defer unlock()
+	sess, lastSeen, err := s.read(path)
+	if err != nil || !s.expired(sess, lastSeen, now) {
+		return false
+	}
+	return os.Remove(path) == nil
+}
@@ -0,0 +1,371 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	sessionLogFile       = "sessions.log"
+	sessionLogLockFile   = ".sessions.log.lock"
+	sessionLogStatusFile = ".sessions.log.sweeper-status"
+)
+
+// logRecord is one line of the session log.
+type logRecord struct {
+	Op      string                     `json:"op"` // create, touch, data or delete
+	At      string                     `json:"at"`
+	ID      string                     `json:"id,omitempty"`
+	Session *Session                   `json:"session,omitempty"`
+	Data    map[string]json.RawMessage `json:"data,omitempty"`
+	Version int                        `json:"version,omitempty"`
+}
+
+// LogSessionStore keeps every session in a single append-only JSON-lines log
+// on the mount. O_APPEND is not atomic across NFS clients, so appends are
+// serialised with a lock on a side file; readers replay only the bytes
+// added since their last read. Sweep compacts the log by renaming a rewritten
+// copy over it, which other instances notice as an inode change and replay
+// from the start.
+type LogSessionStore struct {
+	expiryPolicy
+	dir   string
+	fsync bool
+
+	mu       sync.Mutex
+	sessions map[string]*sessionEntry
+	offset   int64
+	ino      uint64
+}
+
+func NewLogSessionStore(dir string, ttl, idleTTL time.Duration, fsync bool) *LogSessionStore {
+	os.MkdirAll(dir, 0755)
+	// gvisor gofer ignores mode on mkdir over NFS, force correct perms
+	os.Chmod(dir, 0755)
+	return &LogSessionStore{
+		expiryPolicy: expiryPolicy{ttl: ttl, idleTTL: idleTTL},
+		dir:          dir,
+		fsync:        fsync,
+		sessions:     make(map[string]*sessionEntry),
+	}
+}
+
+func (s *LogSessionStore) Create(username, hostname string) (*Session, error) {
+	sess, err := newSession(username, hostname)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.append(logRecord{Op: "create", At: now.UTC().Format(time.RFC3339Nano), Session: sess}); err != nil {
+		return nil, err
+	}
+	s.annotate(sess, now)
+	return sess, nil
+}
+
+func (s *LogSessionStore) Get(sessionID string) (*Session, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.refresh(); err != nil {
+		return nil, err
+	}
+	e, ok := s.sessions[sessionID]
+	if !ok {
+		return nil, fmt.Errorf("session %s: %w", sessionID, os.ErrNotExist)
+	}
+
+	sess := e.sess
+	now := time.Now()
+	if s.expired(&sess, e.lastSeen, now) {
+		s.annotate(&sess, e.lastSeen)
+		return &sess, ErrSessionExpired
+	}
+	if s.shouldTouch(e.lastSeen, now) {
+		if err := s.append(logRecord{Op: "touch", At: now.UTC().Format(time.RFC3339Nano), ID: sessionID}); err == nil {
+			e.lastSeen = now
+		}
+	}
+	s.annotate(&sess, e.lastSeen)
+	return &sess, nil
+}
+
+func (s *LogSessionStore) Peek(sessionID string) (*Session, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.refresh(); err != nil {
+		return nil, err
+	}
+	e, ok := s.sessions[sessionID]
+	if !ok {
+		return nil, fmt.Errorf("session %s: %w", sessionID, os.ErrNotExist)
+	}
+	sess := e.sess
+	s.annotate(&sess, e.lastSeen)
+	if s.expired(&sess, e.lastSeen, time.Now()) {
+		return &sess, ErrSessionExpired
+	}
+	return &sess, nil
+}
+
+func (s *LogSessionStore) Delete(sessionID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.refresh(); err != nil {
+		return err
+	}
+	if _, ok := s.sessions[sessionID]; !ok {
+		return fmt.Errorf("session %s: %w", sessionID, os.ErrNotExist)
+	}
+	return s.append(logRecord{Op: "delete", At: time.Now().UTC().Format(time.RFC3339Nano), ID: sessionID})
+}
+
+// SetData appends a data record. the version check runs after taking the append
+// lock and replaying the log, so two instances can't both write the same version.
+func (s *LogSessionStore) SetData(sessionID string, version int, data map[string]json.RawMessage) (*Session, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	var conflict *Session
+	err := s.appendIf(func() error {
+		e, ok := s.sessions[sessionID]
+		if !ok {
+			return fmt.Errorf("session %s: %w", sessionID, os.ErrNotExist)
+		}
+		if e.sess.Version != version {
+			sess := e.sess
+			s.annotate(&sess, e.lastSeen)
+			conflict = &sess
+			return ErrVersionConflict
+		}
+		return nil
+	}, logRecord{Op: "data", At: now.UTC().Format(time.RFC3339Nano), ID: sessionID, Data: data, Version: version + 1})
+	if errors.Is(err, ErrVersionConflict) {
+		return conflict, err
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	e, ok := s.sessions[sessionID]
+	if !ok {
+		return nil, fmt.Errorf("session %s: %w", sessionID, os.ErrNotExist)
+	}
+	sess := e.sess
+	s.annotate(&sess, e.lastSeen)
+	return &sess, nil
+}
+
+func (s *LogSessionStore) List(cursor string, limit int) ([]Session, string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.refresh(); err != nil {
+		return nil, "", err
+	}
+	page, next := listEntries(s.expiryPolicy, s.sessions, cursor, limit)
+	return page, next, nil
+}
+
+func (s *LogSessionStore) ListByUser(username string) ([]Session, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.refresh(); err != nil {
+		return nil, err
+	}
+	return entriesFor(s.expiryPolicy, s.sessions, username), nil
+}
+
+// Sweep compacts the log down to the live sessions. it shares the append lock,
+// so no record can land in the old inode while the new one is being written.
+func (s *LogSessionStore) Sweep(holder string, interval time.Duration) (*SweepStatus, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	unlock, locked, err := lockPath(filepath.Join(s.dir, sessionLogLockFile), false)
+	if err != nil {
+		return nil, fmt.Errorf("lock session log: %w", err)
+	}
+	if !locked {
+		return nil, nil
+	}
+	defer unlock()
+
+	statusPath := filepath.Join(s.dir, sessionLogStatusFile)
+	if sweptWithin(statusPath, interval) {
+		return nil, nil
+	}
+
+	start := time.Now()
+	if err := s.refresh(); err != nil {
+		return nil, err
+	}
+	status := &SweepStatus{Holder: holder, Scanned: len(s.sessions)}
+	var buf bytes.Buffer
+	for _, e := range s.sessions {
+		if s.expired(&e.sess, e.lastSeen, start) {
+			status.Deleted++
+			continue
+		}
+		sess := e.sess
+		// the create record's time is the last-seen time on replay
+		line, _ := json.Marshal(logRecord{Op: "create", At: e.lastSeen.UTC().Format(time.RFC3339Nano), Session: &sess})
+		buf.Write(append(line, '\n'))
+	}
+	if err := writeFileAtomic(filepath.Join(s.dir, sessionLogFile), buf.Bytes(), 0644, s.fsync); err != nil {
+		return nil, fmt.Errorf("compact session log: %w", err)
+	}
+	if err := s.refresh(); err != nil {
+		return nil, err
+	}
+	status.SweptAt = time.Now().UTC().Format(time.RFC3339Nano)
+	status.Duration = time.Since(start).String()
+
+	data, _ := json.MarshalIndent(status, "", "  ")
+	if err := writeFileAtomic(statusPath, data, 0644, s.fsync); err != nil {
+		return status, fmt.Errorf("write sweep status: %w", err)
+	}
+	return status, nil
+}
+
+// append writes records to the log under the append lock and replays them
+// (plus anything other instances appended first) into memory. callers hold s.mu.
+func (s *LogSessionStore) append(recs ...logRecord) error {
+	return s.appendIf(nil, recs...)
+}
+
+// appendIf is append with a precondition: check runs under the lock against
+// the freshly replayed state, and its error aborts the append.
+func (s *LogSessionStore) appendIf(check func() error, recs ...logRecord) error {
+	var buf bytes.Buffer
+	for _, r := range recs {
+		line, err := json.Marshal(r)
+		if err != nil {
+			return fmt.Errorf("marshal log record: %w", err)
+		}
+		buf.Write(append(line, '\n'))
+	}
+
+	unlock, _, err := lockPath(filepath.Join(s.dir, sessionLogLockFile), true)
+	if err != nil {
+		return fmt.Errorf("lock session log: %w", err)
+	}
+	defer unlock()
+
+	if check != nil {
+		if err := s.refresh(); err != nil {
+			return err
+		}
+		if err := check(); err != nil {
+			return err
+		}
+	}
+
+	// opened after taking the lock so a compaction can't leave us appending to the old inode
+	f, err := os.OpenFile(filepath.Join(s.dir, sessionLogFile), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("open session log: %w", err)
+	}
+	if _, err := f.Write(buf.Bytes()); err != nil {
+		f.Close()
+		return fmt.Errorf("append session log: %w", err)
+	}
+	if s.fsync {
+		if err := f.Sync(); err != nil {
+			f.Close()
+			return fmt.Errorf("fsync session log: %w", err)
+		}
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("close session log: %w", err)
+	}
+	return s.refresh()
+}
+
+// refresh replays log records appended since the last call. a new inode or a
+// file shorter than what was already consumed means the log was compacted, so
+// state is rebuilt from the start. a trailing line without its newline is left
+// for the next call. callers hold s.mu.
+func (s *LogSessionStore) refresh() error {
+	f, err := os.Open(filepath.Join(s.dir, sessionLogFile))
+	if errors.Is(err, os.ErrNotExist) {
+		s.sessions = make(map[string]*sessionEntry)
+		s.offset, s.ino = 0, 0
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("open session log: %w", err)
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return fmt.Errorf("stat session log: %w", err)
+	}
+	var ino uint64
+	if st, ok := info.Sys().(*syscall.Stat_t); ok {
+		ino = st.Ino
+	}
+	if ino != s.ino || info.Size() < s.offset {
+		s.sessions = make(map[string]*sessionEntry)
+		s.offset, s.ino = 0, ino
+	}
+	if info.Size() == s.offset {
+		return nil
+	}
+
+	if _, err := f.Seek(s.offset, io.SeekStart); err != nil {
+		return fmt.Errorf("seek session log: %w", err)
+	}
+	data, err := io.ReadAll(f)
+	if err != nil {
+		return fmt.Errorf("read session log: %w", err)
+	}
+	end := bytes.LastIndexByte(data, '\n')
+	if end < 0 {
+		return nil
+	}
+	for _, line := range bytes.Split(data[:end], []byte{'\n'}) {
+		var r logRecord
+		if json.Unmarshal(line, &r) != nil {
+			continue
+		}
+		s.apply(r)
+	}
+	s.offset += int64(end + 1)
+	return nil
+}
+
+func (s *LogSessionStore) apply(r logRecord) {
+	at, _ := time.Parse(time.RFC3339Nano, r.At)
+	switch r.Op {
+	case "create":
+		if r.Session != nil {
+			s.sessions[r.Session.SessionID] = &sessionEntry{sess: *r.Session, lastSeen: at}
+		}
+	case "touch":
+		if e, ok := s.sessions[r.ID]; ok && at.After(e.lastSeen) {
+			e.lastSeen = at
+		}
+	case "data":
+		if e, ok := s.sessions[r.ID]; ok {
+			e.sess.Data = r.Data
+			e.sess.Version = r.Version
+			if at.After(e.lastSeen) {
+				e.lastSeen = at
+			}
+		}
+	case "delete":
+		delete(s.sessions, r.ID)
+	}
+}
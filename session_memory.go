@@ -0,0 +1,186 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// sessionEntry is a session plus its last-seen time, for backends that
+// can't borrow a file mtime for it.
+type sessionEntry struct {
+	sess     Session
+	lastSeen time.Time
+}
+
+// MemorySessionStore keeps sessions in process memory. nothing is shared
+// between instances, which makes it the baseline the NFS backends are compared
+// against, and a fast store for tests.
+type MemorySessionStore struct {
+	expiryPolicy
+	mu       sync.Mutex
+	sessions map[string]*sessionEntry
+}
+
+func NewMemorySessionStore(ttl, idleTTL time.Duration) *MemorySessionStore {
+	return &MemorySessionStore{
+		expiryPolicy: expiryPolicy{ttl: ttl, idleTTL: idleTTL},
+		sessions:     make(map[string]*sessionEntry),
+	}
+}
+
+func (s *MemorySessionStore) Create(username, hostname string) (*Session, error) {
+	sess, err := newSession(username, hostname)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+
+	s.mu.Lock()
+	s.sessions[sess.SessionID] = &sessionEntry{sess: *sess, lastSeen: now}
+	s.mu.Unlock()
+
+	s.annotate(sess, now)
+	return sess, nil
+}
+
+func (s *MemorySessionStore) Get(sessionID string) (*Session, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, ok := s.sessions[sessionID]
+	if !ok {
+		return nil, fmt.Errorf("session %s: %w", sessionID, os.ErrNotExist)
+	}
+
+	sess := e.sess
+	now := time.Now()
+	if s.expired(&sess, e.lastSeen, now) {
+		s.annotate(&sess, e.lastSeen)
+		return &sess, ErrSessionExpired
+	}
+	if s.shouldTouch(e.lastSeen, now) {
+		e.lastSeen = now
+	}
+	s.annotate(&sess, e.lastSeen)
+	return &sess, nil
+}
+
+func (s *MemorySessionStore) Peek(sessionID string) (*Session, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, ok := s.sessions[sessionID]
+	if !ok {
+		return nil, fmt.Errorf("session %s: %w", sessionID, os.ErrNotExist)
+	}
+	sess := e.sess
+	s.annotate(&sess, e.lastSeen)
+	if s.expired(&sess, e.lastSeen, time.Now()) {
+		return &sess, ErrSessionExpired
+	}
+	return &sess, nil
+}
+
+func (s *MemorySessionStore) Delete(sessionID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.sessions[sessionID]; !ok {
+		return fmt.Errorf("session %s: %w", sessionID, os.ErrNotExist)
+	}
+	delete(s.sessions, sessionID)
+	return nil
+}
+
+func (s *MemorySessionStore) SetData(sessionID string, version int, data map[string]json.RawMessage) (*Session, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, ok := s.sessions[sessionID]
+	if !ok {
+		return nil, fmt.Errorf("session %s: %w", sessionID, os.ErrNotExist)
+	}
+	if e.sess.Version != version {
+		sess := e.sess
+		s.annotate(&sess, e.lastSeen)
+		return &sess, ErrVersionConflict
+	}
+	e.sess.Data = data
+	e.sess.Version++
+	e.lastSeen = time.Now()
+
+	sess := e.sess
+	s.annotate(&sess, e.lastSeen)
+	return &sess, nil
+}
+
+func (s *MemorySessionStore) List(cursor string, limit int) ([]Session, string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	page, next := listEntries(s.expiryPolicy, s.sessions, cursor, limit)
+	return page, next, nil
+}
+
+func (s *MemorySessionStore) ListByUser(username string) ([]Session, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return entriesFor(s.expiryPolicy, s.sessions, username), nil
+}
+
+// Sweep drops expired sessions. there is only one process to coordinate with,
+// so holder and interval are only recorded.
+func (s *MemorySessionStore) Sweep(holder string, interval time.Duration) (*SweepStatus, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	start := time.Now()
+	status := &SweepStatus{Holder: holder, Scanned: len(s.sessions)}
+	for id, e := range s.sessions {
+		if s.expired(&e.sess, e.lastSeen, start) {
+			delete(s.sessions, id)
+			status.Deleted++
+		}
+	}
+	status.SweptAt = time.Now().UTC().Format(time.RFC3339Nano)
+	status.Duration = time.Since(start).String()
+	return status, nil
+}
+
+// listEntries copies and annotates one page of entries in ID order, matching
+// FileSessionStore.List's cursor semantics.
+func listEntries(p expiryPolicy, entries map[string]*sessionEntry, cursor string, limit int) ([]Session, string) {
+	ids := make([]string, 0, len(entries))
+	for id := range entries {
+		if id > cursor {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+	next := ""
+	if limit > 0 && len(ids) > limit {
+		ids = ids[:limit]
+		next = ids[limit-1]
+	}
+
+	sessions := make([]Session, 0, len(ids))
+	for _, id := range ids {
+		sess := entries[id].sess
+		p.annotate(&sess, entries[id].lastSeen)
+		sessions = append(sessions, sess)
+	}
+	return sessions, next
+}
+
+// entriesFor copies and annotates the entries belonging to username.
+func entriesFor(p expiryPolicy, entries map[string]*sessionEntry, username string) []Session {
+	var sessions []Session
+	for _, e := range entries {
+		if e.sess.Username != username {
+			continue
+		}
+		sess := e.sess
+		p.annotate(&sess, e.lastSeen)
+		sessions = append(sessions, sess)
+	}
+	return sessions
+}
@@ -0,0 +1,408 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestSessionIdleExpiry(t *testing.T) {
+	store := NewFileSessionStore(t.TempDir(), time.Hour, time.Minute, false)
+	sess, err := store.Create("alice", "test-host")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Get(sess.SessionID); err != nil {
+		t.Fatalf("fresh session: %v", err)
+	}
+
+	// backdate last-seen (the file mtime) past the idle TTL
+	path := store.shardPath(sess.SessionID)
+	old := time.Now().Add(-2 * time.Minute)
+	if err := os.Chtimes(path, old, old); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Get(sess.SessionID); !errors.Is(err, ErrSessionExpired) {
+		t.Fatalf("idle session: got %v, want ErrSessionExpired", err)
+	}
+}
+
+func TestSessionSlidingExpiry(t *testing.T) {
+	store := NewFileSessionStore(t.TempDir(), time.Hour, time.Minute, false)
+	sess, err := store.Create("alice", "test-host")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := store.shardPath(sess.SessionID)
+	old := time.Now().Add(-50 * time.Second).Truncate(time.Second)
+	os.Chtimes(path, old, old)
+
+	// Peek reads without counting as activity
+	if _, err := store.Peek(sess.SessionID); err != nil {
+		t.Fatal(err)
+	}
+	if info, _ := os.Stat(path); !info.ModTime().Equal(old) {
+		t.Fatalf("Peek slid last-seen: mtime %s, want %s", info.ModTime(), old)
+	}
+
+	got, err := store.Get(sess.SessionID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, _ := os.Stat(path)
+	if time.Since(info.ModTime()) > 5*time.Second {
+		t.Fatalf("Get did not slide last-seen: mtime %s", info.ModTime())
+	}
+	if expires, _ := time.Parse(time.RFC3339, got.ExpiresAt); time.Until(expires) < 50*time.Second {
+		t.Fatalf("expires_at %s not extended", got.ExpiresAt)
+	}
+}
+
+func TestSessionAbsoluteExpiry(t *testing.T) {
+	store := NewFileSessionStore(t.TempDir(), time.Nanosecond, time.Hour, false)
+	sess, err := store.Create("bob", "test-host")
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(1100 * time.Millisecond) // created_at has second precision
+	if _, err := store.Get(sess.SessionID); !errors.Is(err, ErrSessionExpired) {
+		t.Fatalf("got %v, want ErrSessionExpired", err)
+	}
+}
+
+func TestSessionSweep(t *testing.T) {
+	store := NewFileSessionStore(t.TempDir(), time.Hour, time.Minute, false)
+	live, _ := store.Create("alice", "test-host")
+	dead, _ := store.Create("bob", "test-host")
+	old := time.Now().Add(-time.Hour)
+	os.Chtimes(store.shardPath(dead.SessionID), old, old)
+
+	status, err := store.Sweep("sweeper-a", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status == nil || status.Deleted != 1 || status.Scanned != 2 {
+		t.Fatalf("sweep status = %+v, want 1 of 2 deleted", status)
+	}
+	if _, err := store.Get(live.SessionID); err != nil {
+		t.Fatalf("live session swept: %v", err)
+	}
+	if _, err := store.Get(dead.SessionID); !os.IsNotExist(err) {
+		t.Fatalf("expired session still present: %v", err)
+	}
+
+	// sessions not yet migrated to the sharded layout expire too
+	flatID, _ := generateSessionID()
+	os.WriteFile(store.flatPath(flatID), []byte(`{"username":"carol","session_id":"`+flatID+`"}`), 0644)
+	os.Chtimes(store.flatPath(flatID), old, old)
+	os.Remove(filepath.Join(store.dir, sweepStatusFile))
+	status, err = store.Sweep("sweeper-a", time.Minute)
+	if err != nil || status == nil || status.Deleted != 1 {
+		t.Fatalf("flat sweep status = %+v err=%v, want 1 deleted", status, err)
+	}
+	if _, err := os.Stat(store.flatPath(flatID)); !os.IsNotExist(err) {
+		t.Fatalf("expired flat session still present: %v", err)
+	}
+
+	// a second instance within the interval must skip
+	status, err = store.Sweep("sweeper-b", time.Minute)
+	if err != nil || status != nil {
+		t.Fatalf("second sweep within interval: status=%+v err=%v, want skipped", status, err)
+	}
+}
+
+// TestSessionNoPartialReads creates sessions from several goroutines while others
+// call Get, List and read every visible file the way the session-watcher does.
+// with write-then-rename no reader may ever see a truncated or empty session.
+func TestSessionNoPartialReads(t *testing.T) {
+	base := testBasePath(t)
+	dir := filepath.Join(base, "test-session-partial")
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	store := NewFileSessionStore(dir, time.Hour, time.Hour, false)
+
+	const (
+		writers   = 4
+		perWriter = 100
+		readers   = 4
+	)
+	created := make(chan string, writers*perWriter)
+	done := make(chan struct{})
+	var partial, gets, scans atomic.Int64
+	var firstPartial atomic.Value
+
+	var readWG sync.WaitGroup
+	for r := 0; r < readers; r++ {
+		readWG.Add(1)
+		go func() {
+			defer readWG.Done()
+			for {
+				select {
+				case <-done:
+					return
+				case id := <-created:
+					gets.Add(1)
+					if _, err := store.Get(id); err != nil {
+						partial.Add(1)
+						firstPartial.CompareAndSwap(nil, "get "+id+": "+err.Error())
+					}
+				default:
+				}
+				if _, _, err := store.List("", 0); err != nil {
+					t.Errorf("list: %v", err)
+					return
+				}
+				filepath.WalkDir(dir, func(path string, d os.DirEntry, err error) error {
+					if err != nil || d.IsDir() || !strings.HasSuffix(d.Name(), ".json") {
+						return nil
+					}
+					scans.Add(1)
+					if _, _, err := store.read(path); err != nil && !os.IsNotExist(err) {
+						partial.Add(1)
+						firstPartial.CompareAndSwap(nil, d.Name()+": "+err.Error())
+					}
+					return nil
+				})
+			}
+		}()
+	}
+
+	var writeWG sync.WaitGroup
+	for w := 0; w < writers; w++ {
+		writeWG.Add(1)
+		go func() {
+			defer writeWG.Done()
+			for i := 0; i < perWriter; i++ {
+				sess, err := store.Create("alice", "test-host")
+				if err != nil {
+					t.Errorf("create: %v", err)
+					return
+				}
+				created <- sess.SessionID
+			}
+		}()
+	}
+	writeWG.Wait()
+	close(done)
+	readWG.Wait()
+
+	t.Logf("gets=%d file reads=%d partial=%d", gets.Load(), scans.Load(), partial.Load())
+	if partial.Load() > 0 {
+		t.Fatalf("%d partial session reads, first: %v", partial.Load(), firstPartial.Load())
+	}
+	list, _, err := store.List("", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != writers*perWriter {
+		t.Fatalf("List returned %d sessions, want %d", len(list), writers*perWriter)
+	}
+}
+
+// TestSessionStoreBackends runs the same lifecycle against every SESSION_STORE backend.
+func TestSessionStoreBackends(t *testing.T) {
+	for _, backend := range []string{"file", "memory", "log"} {
+		t.Run(backend, func(t *testing.T) {
+			store, err := newSessionStore(backend, t.TempDir(), time.Hour, time.Hour, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			a, err := store.Create("alice", "test-host")
+			if err != nil {
+				t.Fatal(err)
+			}
+			b, _ := store.Create("bob", "test-host")
+
+			got, err := store.Get(a.SessionID)
+			if err != nil || got.Username != "alice" || got.ExpiresAt == "" {
+				t.Fatalf("Get = %+v, %v", got, err)
+			}
+			if list, _, _ := store.List("", 0); len(list) != 2 {
+				t.Fatalf("List returned %d sessions, want 2", len(list))
+			}
+			if err := store.Delete(b.SessionID); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := store.Get(b.SessionID); err == nil {
+				t.Fatal("deleted session still readable")
+			}
+			if list, _, _ := store.List("", 0); len(list) != 1 || list[0].SessionID != a.SessionID {
+				t.Fatalf("List after delete = %+v", list)
+			}
+		})
+	}
+	if _, err := newSessionStore("sqlite", t.TempDir(), time.Hour, time.Hour, false); err == nil {
+		t.Fatal("unknown backend accepted")
+	}
+}
+
+// TestLogSessionStoreSharedLog points two instances at one log: each sees the
+// other's appends, and compaction by one is picked up by the other.
+func TestLogSessionStoreSharedLog(t *testing.T) {
+	dir := t.TempDir()
+	a := NewLogSessionStore(dir, time.Hour, time.Minute, false)
+	b := NewLogSessionStore(dir, time.Hour, time.Minute, false)
+
+	live, err := a.Create("alice", "host-a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.Get(live.SessionID); err != nil {
+		t.Fatalf("instance b can't see a's session: %v", err)
+	}
+	gone, _ := b.Create("bob", "host-b")
+	if err := a.Delete(gone.SessionID); err != nil {
+		t.Fatalf("instance a can't delete b's session: %v", err)
+	}
+	if _, err := b.Get(gone.SessionID); err == nil {
+		t.Fatal("instance b still sees session deleted by a")
+	}
+
+	// an idle session written straight into the log, then swept by b
+	old := time.Now().Add(-time.Hour).UTC()
+	idle := &Session{Username: "bob", SessionID: "idle", CreatedAt: old.Format(time.RFC3339), CreatedBy: "host-a"}
+	a.mu.Lock()
+	a.append(logRecord{Op: "create", At: old.Format(time.RFC3339Nano), Session: idle})
+	a.mu.Unlock()
+
+	status, err := b.Sweep("host-b", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status == nil || status.Deleted != 1 || status.Scanned != 2 {
+		t.Fatalf("sweep status = %+v, want 1 of 2 deleted", status)
+	}
+	if _, err := a.Get("idle"); err == nil {
+		t.Fatal("instance a still sees swept session after compaction")
+	}
+	if _, err := a.Get(live.SessionID); err != nil {
+		t.Fatalf("live session lost in compaction: %v", err)
+	}
+	if list, _, _ := a.List("", 0); len(list) != 1 {
+		t.Fatalf("List after compaction returned %d sessions, want 1", len(list))
+	}
+}
+
+func TestSessionListPagination(t *testing.T) {
+	for _, backend := range []string{"file", "memory", "log"} {
+		t.Run(backend, func(t *testing.T) {
+			store, _ := newSessionStore(backend, t.TempDir(), time.Hour, time.Hour, false)
+			want := map[string]bool{}
+			for i := 0; i < 25; i++ {
+				sess, err := store.Create("alice", "test-host")
+				if err != nil {
+					t.Fatal(err)
+				}
+				want[sess.SessionID] = true
+			}
+
+			cursor, last, pages := "", "", 0
+			seen := map[string]bool{}
+			for {
+				page, next, err := store.List(cursor, 10)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if len(page) > 10 {
+					t.Fatalf("page of %d, limit 10", len(page))
+				}
+				for _, s := range page {
+					if s.SessionID <= last || seen[s.SessionID] {
+						t.Fatalf("session %s out of order or repeated", s.SessionID)
+					}
+					last = s.SessionID
+					seen[s.SessionID] = true
+				}
+				pages++
+				if next == "" {
+					break
+				}
+				cursor = next
+			}
+			if len(seen) != len(want) || pages > 4 {
+				t.Fatalf("listed %d of %d sessions in %d pages", len(seen), len(want), pages)
+			}
+		})
+	}
+}
+
+// TestSessionListExactPage lists exactly limit sessions: the full page must not
+// hand out a cursor to an empty next page, on any backend.
+func TestSessionListExactPage(t *testing.T) {
+	for _, backend := range []string{"file", "memory", "log"} {
+		t.Run(backend, func(t *testing.T) {
+			store, _ := newSessionStore(backend, t.TempDir(), time.Hour, time.Hour, false)
+			for i := 0; i < 10; i++ {
+				if _, err := store.Create("alice", "test-host"); err != nil {
+					t.Fatal(err)
+				}
+			}
+			page, next, err := store.List("", 10)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(page) != 10 || next != "" {
+				t.Fatalf("page of %d with next %q, want 10 and no cursor", len(page), next)
+			}
+
+			page, next, _ = store.List("", 5)
+			if len(page) != 5 || next != page[4].SessionID {
+				t.Fatalf("page of %d with next %q, want 5 and a cursor", len(page), next)
+			}
+			page, next, _ = store.List(next, 5)
+			if len(page) != 5 || next != "" {
+				t.Fatalf("last page of %d with next %q, want 5 and no cursor", len(page), next)
+			}
+		})
+	}
+}
+
+func TestFileSessionMigrate(t *testing.T) {
+	store := NewFileSessionStore(t.TempDir(), time.Hour, time.Hour, false)
+	old := time.Now().Add(-10 * time.Minute).Truncate(time.Second)
+	var ids []string
+	for i := 0; i < 5; i++ {
+		id, _ := generateSessionID()
+		data := `{"username":"alice","session_id":"` + id + `","created_at":"` + time.Now().UTC().Format(time.RFC3339) + `"}`
+		if err := os.WriteFile(store.flatPath(id), []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+		os.Chtimes(store.flatPath(id), old, old)
+		ids = append(ids, id)
+	}
+
+	// unmigrated sessions still resolve, but only sharded ones are listed
+	if _, err := store.Get(ids[0]); err != nil {
+		t.Fatalf("flat session before migration: %v", err)
+	}
+	if list, _, _ := store.List("", 0); len(list) != 0 {
+		t.Fatalf("List saw %d flat sessions", len(list))
+	}
+
+	if moved, _, err := store.Migrate(true); err != nil || moved != 5 {
+		t.Fatalf("dry run: moved=%d err=%v", moved, err)
+	}
+	if _, err := os.Stat(store.flatPath(ids[1])); err != nil {
+		t.Fatal("dry run moved a file")
+	}
+	if moved, skipped, err := store.Migrate(false); err != nil || moved != 5 || skipped != 0 {
+		t.Fatalf("migrate: moved=%d skipped=%d err=%v", moved, skipped, err)
+	}
+	if list, _, _ := store.List("", 0); len(list) != 5 {
+		t.Fatalf("List after migration returned %d sessions, want 5", len(list))
+	}
+	info, err := os.Stat(store.shardPath(ids[1]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !info.ModTime().Equal(old) {
+		t.Fatalf("migration reset last-seen: mtime %s, want %s", info.ModTime(), old)
+	}
+	if moved, _, _ := store.Migrate(false); moved != 0 {
+		t.Fatalf("second migration moved %d files", moved)
+	}
+}
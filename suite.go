@@ -2,6 +2,7 @@ package main
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
@@ -68,7 +69,7 @@ type FullSuiteResult struct {
 // coreOps returns the list of NFS operations to test.
 // these run in order — some depend on artifacts from earlier ops (e.g. read depends on create).
 func coreOps() []op {
-	return []op{
+	ops := []op{
 		{"create_file", opCreateFile},
 		{"read_file", opReadFile},
 		{"stat_file", opStatFile},
@@ -98,6 +99,22 @@ func coreOps() []op {
 		{"exclusive_create", opExclusiveCreate},
 		{"seek_read_write", opSeekReadWrite},
 	}
+	ops = append(ops, timestampOps()...)
+	ops = append(ops, unlinkOps()...)
+	ops = append(ops, renameOps()...)
+	ops = append(ops, syncOps()...)
+	ops = append(ops, appendOps()...)
+	ops = append(ops, writeAtOps()...)
+	ops = append(ops, largeFileOps()...)
+	ops = append(ops, fallocateOps()...)
+	ops = append(ops, mmapOps()...)
+	ops = append(ops, directOps()...)
+	ops = append(ops, filenameOps()...)
+	ops = append(ops, treeOps()...)
+	ops = append(ops, capacityOps()...)
+	ops = append(ops, hardlinkOps()...)
+	ops = append(ops, specialOps()...)
+	return ops
 }
 
 // sharedOps returns additional operations specific to the shared directory.
@@ -109,6 +126,7 @@ func sharedOps(runID string) []op {
 		}},
 		{"list_existing", opListExisting},
 		{"read_cross_run", opReadCrossRun},
+		{"inode_cross_run", opInodeCrossRun},
 	}
 }
 
@@ -157,6 +175,49 @@ func summarize(results []TestResult) SuiteSummary {
 	return s
 }
 
+// errnoName returns the symbolic errno (e.g. "ENOTEMPTY") wrapped in err,
+// falling back to the error text when there is none.
+func errnoName(err error) string {
+	var errno syscall.Errno
+	if errors.As(err, &errno) {
+		if name, ok := errnoNames[errno]; ok {
+			return name
+		}
+		return fmt.Sprintf("errno %d (%v)", int(errno), errno)
+	}
+	return err.Error()
+}
+
+var errnoNames = map[syscall.Errno]string{
+	syscall.EPERM:        "EPERM",
+	syscall.ENOENT:       "ENOENT",
+	syscall.EIO:          "EIO",
+	syscall.EBADF:        "EBADF",
+	syscall.EAGAIN:       "EAGAIN",
+	syscall.EACCES:       "EACCES",
+	syscall.EBUSY:        "EBUSY",
+	syscall.EEXIST:       "EEXIST",
+	syscall.EXDEV:        "EXDEV",
+	syscall.ENOTDIR:      "ENOTDIR",
+	syscall.EISDIR:       "EISDIR",
+	syscall.EINVAL:       "EINVAL",
+	syscall.EFBIG:        "EFBIG",
+	syscall.ENOSPC:       "ENOSPC",
+	syscall.EROFS:        "EROFS",
+	syscall.EMLINK:       "EMLINK",
+	syscall.ENAMETOOLONG: "ENAMETOOLONG",
+	syscall.ENOTEMPTY:    "ENOTEMPTY",
+	syscall.ELOOP:        "ELOOP",
+	syscall.EOPNOTSUPP:   "EOPNOTSUPP",
+	syscall.ENOSYS:       "ENOSYS",
+	syscall.EDQUOT:       "EDQUOT",
+	syscall.ESTALE:       "ESTALE",
+	syscall.ENOTTY:       "ENOTTY",
+	syscall.EILSEQ:       "EILSEQ",
+	syscall.EOVERFLOW:    "EOVERFLOW",
+	syscall.ENXIO:        "ENXIO",
+}
+
 // RunIsolatedSuite creates a unique directory and runs all core ops, then cleans up.
 func RunIsolatedSuite(basePath, runID string) SuiteResult {
 	dir := filepath.Join(basePath, fmt.Sprintf("test-isolated-%s", runID))
@@ -637,19 +698,44 @@ func opHardlink(dir string) (opResult, error) {
 	src := filepath.Join(dir, "test.txt")
 	dst := filepath.Join(dir, "test-hardlink.txt")
 
-	before := "test-hardlink.txt exists=false"
+	srcStat, err := statT(src)
+	if err != nil {
+		return opResult{Context: "os.Link hard link"}, err
+	}
+	before := fmt.Sprintf("test-hardlink.txt exists=false, test.txt nlink=%d ino=%d", srcStat.Nlink, srcStat.Ino)
 
 	if err := os.Link(src, dst); err != nil {
 		return opResult{Before: before, Context: "os.Link hard link"}, err
 	}
+	linkedSrc, err := statT(src)
+	if err != nil {
+		os.Remove(dst)
+		return opResult{Before: before, Context: "os.Link hard link"}, err
+	}
+	linkedDst, err := statT(dst)
+	if err != nil {
+		os.Remove(dst)
+		return opResult{Before: before, Context: "os.Link hard link"}, err
+	}
 	srcData, _ := os.ReadFile(src)
 	dstData, _ := os.ReadFile(dst)
 	os.Remove(dst)
+	unlinkedSrc, err := statT(src)
+	if err != nil {
+		return opResult{Before: before, Context: "os.Link hard link"}, err
+	}
+
+	after := fmt.Sprintf("nlink linked=%d unlinked=%d, ino src=%d dst=%d, content matches (%d bytes)", linkedSrc.Nlink, unlinkedSrc.Nlink, linkedSrc.Ino, linkedDst.Ino, len(srcData))
 	if string(srcData) != string(dstData) {
-		return opResult{Before: before, Context: "os.Link hard link"}, fmt.Errorf("hardlink content mismatch")
+		return opResult{Before: before, After: after, Context: "os.Link hard link"}, fmt.Errorf("hardlink content mismatch")
+	}
+	if linkedDst.Ino != linkedSrc.Ino {
+		return opResult{Before: before, After: after, Context: "os.Link hard link"}, fmt.Errorf("hardlink has inode %d, want %d", linkedDst.Ino, linkedSrc.Ino)
+	}
+	if linkedSrc.Nlink != srcStat.Nlink+1 || unlinkedSrc.Nlink != srcStat.Nlink {
+		return opResult{Before: before, After: after, Context: "os.Link hard link"}, fmt.Errorf("nlink %d -> %d -> %d, want +1 then back", srcStat.Nlink, linkedSrc.Nlink, unlinkedSrc.Nlink)
 	}
-	after := fmt.Sprintf("test-hardlink.txt content matches (%d bytes)", len(srcData))
-	return opResult{Before: before, After: after, Context: "os.Link hard link", Details: "hardlink created, content matches"}, nil
+	return opResult{Before: before, After: after, Context: "os.Link hard link", Details: "hardlink created, shares inode, nlink tracked link and unlink"}, nil
 }
 
 func opMkfifo(dir string) (opResult, error) {
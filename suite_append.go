@@ -0,0 +1,158 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+)
+
+// appendOps returns ops covering O_APPEND contention on a single file.
+// NFS has no atomic append on the wire: the client computes the offset from its
+// cached size, so appends from several clients (or racing local writers) can tear.
+func appendOps() []op {
+	return []op{
+		{"append_contention", opAppendContention},
+	}
+}
+
+const (
+	appendRecordSize   = 64
+	appendGoroutines   = 8
+	appendProcesses    = 3
+	appendRecordsEach  = 200
+	appendProcWriterID = 100
+)
+
+// appendRecord builds a fixed-size, newline-terminated record whose body is
+// derived from writer and seq, so a reader can detect torn or merged records.
+func appendRecord(writer, seq int) []byte {
+	rec := bytes.Repeat([]byte{byte('A' + (writer+seq)%26)}, appendRecordSize)
+	copy(rec, fmt.Sprintf("%04d:%06d:", writer, seq))
+	rec[appendRecordSize-1] = '\n'
+	return rec
+}
+
+// appendRecords writes count records for writer to path, one O_APPEND write each.
+func appendRecords(path string, writer, count int) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	for seq := 0; seq < count; seq++ {
+		if _, err := f.Write(appendRecord(writer, seq)); err != nil {
+			return fmt.Errorf("writer %d seq %d: %w", writer, seq, err)
+		}
+	}
+	return nil
+}
+
+// childAppend is the child-process side of append_contention: args are path, writer, count.
+func childAppend(args []string) error {
+	if len(args) != 3 {
+		return fmt.Errorf("usage: <path> <writer> <count>")
+	}
+	writer, err := strconv.Atoi(args[1])
+	if err != nil {
+		return err
+	}
+	count, err := strconv.Atoi(args[2])
+	if err != nil {
+		return err
+	}
+	return appendRecords(args[0], writer, count)
+}
+
+// appendAudit is the result of parsing an append log.
+type appendAudit struct {
+	valid, torn, duplicate, lost int
+}
+
+func auditAppendLog(data []byte, writers []int, perWriter int) appendAudit {
+	var a appendAudit
+	seen := map[[2]int]bool{}
+	for _, line := range bytes.SplitAfter(data, []byte("\n")) {
+		if len(line) == 0 {
+			continue
+		}
+		var writer, seq int
+		if len(line) != appendRecordSize {
+			a.torn++
+			continue
+		}
+		if _, err := fmt.Sscanf(string(line[:12]), "%04d:%06d:", &writer, &seq); err != nil || !bytes.Equal(line, appendRecord(writer, seq)) {
+			a.torn++
+			continue
+		}
+		key := [2]int{writer, seq}
+		if seen[key] {
+			a.duplicate++
+			continue
+		}
+		seen[key] = true
+		a.valid++
+	}
+	a.lost = len(writers)*perWriter - a.valid
+	return a
+}
+
+func opAppendContention(dir string) (opResult, error) {
+	ctx := fmt.Sprintf("%d goroutines + %d processes O_APPEND %d-byte records to one file", appendGoroutines, appendProcesses, appendRecordSize)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return opResult{Context: ctx}, err
+	}
+	path := filepath.Join(dir, "append-contention.log")
+	os.Remove(path)
+	defer os.Remove(path)
+
+	var writers []int
+	var argSets [][]string
+	for p := 0; p < appendProcesses; p++ {
+		id := appendProcWriterID + p
+		writers = append(writers, id)
+		argSets = append(argSets, []string{path, strconv.Itoa(id), strconv.Itoa(appendRecordsEach)})
+	}
+	before := fmt.Sprintf("%d writers x %d records", appendGoroutines+appendProcesses, appendRecordsEach)
+
+	var wg sync.WaitGroup
+	errs := make(chan error, appendGoroutines+1)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		if err := runChildren("append", argSets); err != nil {
+			errs <- err
+		}
+	}()
+	for g := 0; g < appendGoroutines; g++ {
+		writers = append(writers, g)
+		wg.Add(1)
+		go func(id int) {
+			defer wg.Done()
+			if err := appendRecords(path, id, appendRecordsEach); err != nil {
+				errs <- err
+			}
+		}(g)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		return opResult{Before: before, Context: ctx}, err
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return opResult{Before: before, Context: ctx}, fmt.Errorf("read log: %w", err)
+	}
+	audit := auditAppendLog(data, writers, appendRecordsEach)
+	expected := len(writers) * appendRecordsEach * appendRecordSize
+
+	after := fmt.Sprintf("size=%d expected=%d", len(data), expected)
+	details := fmt.Sprintf("valid=%d torn=%d duplicate=%d lost=%d", audit.valid, audit.torn, audit.duplicate, audit.lost)
+	if audit.torn > 0 || audit.lost > 0 || audit.duplicate > 0 {
+		return opResult{Before: before, After: after, Context: ctx, Details: details}, fmt.Errorf("O_APPEND not atomic: %s", details)
+	}
+	return opResult{Before: before, After: after, Context: ctx, Details: details}, nil
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// fillMaxBytes caps how much fill_quota writes before giving up, so the op can
+// run against a small loopback filesystem or a quota-limited directory without
+// filling a real export.
+var fillMaxBytes = int64(getEnvInt("FILL_MAX_MB", 64)) << 20
+
+// StatfsInfo is the capacity of the filesystem holding a path.
+type StatfsInfo struct {
+	BlockSize      int64  `json:"block_size"`
+	TotalBytes     uint64 `json:"total_bytes"`
+	FreeBytes      uint64 `json:"free_bytes"`
+	AvailableBytes uint64 `json:"available_bytes"`
+	TotalInodes    uint64 `json:"total_inodes"`
+	FreeInodes     uint64 `json:"free_inodes"`
+	UsedPercent    string `json:"used_percent"`
+}
+
+func statfsInfo(path string) (StatfsInfo, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(path, &st); err != nil {
+		return StatfsInfo{}, err
+	}
+	bs := uint64(st.Bsize)
+	info := StatfsInfo{
+		BlockSize:      st.Bsize,
+		TotalBytes:     st.Blocks * bs,
+		FreeBytes:      st.Bfree * bs,
+		AvailableBytes: st.Bavail * bs,
+		TotalInodes:    st.Files,
+		FreeInodes:     st.Ffree,
+	}
+	if st.Blocks > 0 {
+		info.UsedPercent = fmt.Sprintf("%.1f", float64(st.Blocks-st.Bfree)/float64(st.Blocks)*100)
+	}
+	return info, nil
+}
+
+// capacityOps returns ops covering out-of-space behaviour.
+func capacityOps() []op {
+	return []op{
+		{"fill_quota", opFillQuota},
+	}
+}
+
+// fillOutcome records where a fill stopped and how.
+type fillOutcome struct {
+	acked   int64
+	stage   string
+	err     error
+	errName string
+}
+
+// fillFile writes 1MB chunks to path until an error or limit bytes, then fsyncs and closes,
+// recording the first stage (write, fsync, close) that reported an error.
+func fillFile(path string, limit int64) fillOutcome {
+	f, err := os.Create(path)
+	if err != nil {
+		return fillOutcome{stage: "create", err: err}
+	}
+	chunk := make([]byte, 1<<20)
+	for i := range chunk {
+		chunk[i] = byte(i)
+	}
+
+	var out fillOutcome
+	for out.acked < limit {
+		n, err := f.Write(chunk)
+		out.acked += int64(n)
+		if err != nil {
+			out.stage, out.err = "write", err
+			break
+		}
+	}
+	if err := f.Sync(); err != nil && out.err == nil {
+		out.stage, out.err = "fsync", err
+	}
+	if err := f.Close(); err != nil && out.err == nil {
+		out.stage, out.err = "close", err
+	}
+	if out.err != nil {
+		out.errName = errnoName(out.err)
+	}
+	return out
+}
+
+func opFillQuota(dir string) (opResult, error) {
+	ctx := fmt.Sprintf("write until ENOSPC/EDQUOT or FILL_MAX_MB=%d", fillMaxBytes>>20)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return opResult{Context: ctx}, err
+	}
+	path := filepath.Join(dir, "fill-quota.bin")
+	defer os.Remove(path)
+
+	st, err := statfsInfo(dir)
+	if err != nil {
+		return opResult{Context: ctx}, fmt.Errorf("statfs: %w", err)
+	}
+	before := fmt.Sprintf("available=%d bytes free_inodes=%d", st.AvailableBytes, st.FreeInodes)
+
+	out := fillFile(path, fillMaxBytes)
+	info, statErr := os.Stat(path)
+	var onDisk int64
+	if statErr == nil {
+		onDisk = info.Size()
+	}
+
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return opResult{Before: before, Context: ctx}, fmt.Errorf("remove fill file: %w", err)
+	}
+	stAfter, _ := statfsInfo(dir)
+	after := fmt.Sprintf("acked=%d on_disk=%d, available after cleanup=%d", out.acked, onDisk, stAfter.AvailableBytes)
+
+	if out.err == nil {
+		return opResult{Before: before, After: after, Context: ctx, Details: fmt.Sprintf("no limit hit within %d bytes", fillMaxBytes)}, nil
+	}
+	if out.stage == "create" {
+		return opResult{Before: before, After: after, Context: ctx}, fmt.Errorf("create fill file: %w", out.err)
+	}
+
+	details := fmt.Sprintf("%s surfaced on %s after %d acked bytes", out.errName, out.stage, out.acked)
+	switch {
+	case onDisk < out.acked:
+		details += fmt.Sprintf(", %d acked bytes lost", out.acked-onDisk)
+	case onDisk > 0:
+		details += fmt.Sprintf(", %d bytes of partial data kept in the file", onDisk)
+	}
+	if !errors.Is(out.err, syscall.ENOSPC) && !errors.Is(out.err, syscall.EDQUOT) {
+		return opResult{Before: before, After: after, Context: ctx, Details: details}, fmt.Errorf("unexpected error when full: %w", out.err)
+	}
+	return opResult{Before: before, After: after, Context: ctx, Details: details}, nil
+}
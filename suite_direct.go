@@ -0,0 +1,220 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// directOps returns ops covering O_DIRECT, which databases often insist on.
+// the NFS client accepts any alignment for O_DIRECT, while local block
+// filesystems usually require logical-block alignment of buffer, offset and size.
+func directOps() []op {
+	return []op{
+		{"odirect_alignment", opODirectAlignment},
+		{"odirect_throughput", opODirectThroughput},
+		{"odirect_sees_buffered", opODirectSeesBuffered},
+	}
+}
+
+const (
+	directChunk = 1 << 20
+	directTotal = 16 << 20
+)
+
+// alignedBuf returns a page-aligned buffer of size bytes starting skew bytes into the page.
+// the returned release func unmaps it.
+func alignedBuf(size, skew int) ([]byte, func(), error) {
+	pages := (size+skew)/os.Getpagesize() + 1
+	mem, err := unix.Mmap(-1, 0, pages*os.Getpagesize(), unix.PROT_READ|unix.PROT_WRITE, unix.MAP_ANON|unix.MAP_PRIVATE)
+	if err != nil {
+		return nil, nil, err
+	}
+	return mem[skew : skew+size], func() { unix.Munmap(mem) }, nil
+}
+
+// openDirect opens path with O_DIRECT; ok is false if the filesystem refuses the flag.
+func openDirect(path string, flag int) (f *os.File, ok bool, err error) {
+	f, err = os.OpenFile(path, flag|unix.O_DIRECT, 0644)
+	if errors.Is(err, unix.EINVAL) {
+		return nil, false, nil
+	}
+	return f, err == nil, err
+}
+
+func opODirectAlignment(dir string) (opResult, error) {
+	ctx := "probe O_DIRECT buffer/offset/size alignments"
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return opResult{Context: ctx}, err
+	}
+	path := filepath.Join(dir, "direct-align.bin")
+	defer os.Remove(path)
+	if err := os.WriteFile(path, make([]byte, 64<<10), 0644); err != nil {
+		return opResult{Context: ctx}, err
+	}
+
+	f, ok, err := openDirect(path, os.O_RDWR)
+	if !ok {
+		if err != nil {
+			return opResult{Context: ctx}, err
+		}
+		return opResult{Context: ctx, Details: "O_DIRECT open rejected with EINVAL (not supported on this filesystem)"}, nil
+	}
+	defer f.Close()
+
+	probes := []struct {
+		name             string
+		bufSkew, off, sz int
+	}{
+		{"4096/4096/4096", 0, 4096, 4096},
+		{"512/512/512", 512, 512, 512},
+		{"buf+1", 1, 4096, 4096},
+		{"off+1", 0, 4097, 4096},
+		{"size=1000", 0, 4096, 1000},
+		{"1/1/1", 1, 1, 1},
+	}
+
+	var accepted, rejected []string
+	for _, p := range probes {
+		buf, release, err := alignedBuf(p.sz, p.bufSkew)
+		if err != nil {
+			return opResult{Context: ctx}, fmt.Errorf("alloc buffer: %w", err)
+		}
+		_, werr := f.WriteAt(buf, int64(p.off))
+		_, rerr := f.ReadAt(buf, int64(p.off))
+		release()
+		switch {
+		case werr == nil && rerr == nil:
+			accepted = append(accepted, p.name)
+		case werr != nil:
+			rejected = append(rejected, fmt.Sprintf("%s(write %s)", p.name, errnoName(werr)))
+		default:
+			rejected = append(rejected, fmt.Sprintf("%s(read %s)", p.name, errnoName(rerr)))
+		}
+	}
+
+	if len(accepted) == 0 {
+		return opResult{Context: ctx, Details: "rejected: " + strings.Join(rejected, " ")}, fmt.Errorf("O_DIRECT open succeeded but no alignment was accepted")
+	}
+	details := "accepted: " + strings.Join(accepted, " ")
+	if len(rejected) > 0 {
+		details += "; rejected: " + strings.Join(rejected, " ")
+	} else {
+		details += "; no alignment requirement (NFS-style)"
+	}
+	return opResult{Context: ctx, Details: details}, nil
+}
+
+// timedChunks writes then reads directTotal bytes in directChunk pieces through f,
+// returning write and read throughput in MB/s.
+func timedChunks(f *os.File, buf []byte) (writeMBs, readMBs float64, err error) {
+	start := time.Now()
+	for off := 0; off < directTotal; off += directChunk {
+		if _, err := f.WriteAt(buf, int64(off)); err != nil {
+			return 0, 0, fmt.Errorf("write at %d: %w", off, err)
+		}
+	}
+	if err := f.Sync(); err != nil {
+		return 0, 0, fmt.Errorf("fsync: %w", err)
+	}
+	writeMBs = float64(directTotal) / time.Since(start).Seconds() / (1 << 20)
+
+	start = time.Now()
+	for off := 0; off < directTotal; off += directChunk {
+		if _, err := f.ReadAt(buf, int64(off)); err != nil {
+			return 0, 0, fmt.Errorf("read at %d: %w", off, err)
+		}
+	}
+	readMBs = float64(directTotal) / time.Since(start).Seconds() / (1 << 20)
+	return writeMBs, readMBs, nil
+}
+
+func opODirectThroughput(dir string) (opResult, error) {
+	ctx := "16MB in 1MB chunks: O_DIRECT vs buffered (+fsync)"
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return opResult{Context: ctx}, err
+	}
+	bufferedPath := filepath.Join(dir, "direct-buffered.bin")
+	directPath := filepath.Join(dir, "direct-direct.bin")
+	defer os.Remove(bufferedPath)
+	defer os.Remove(directPath)
+
+	buf, release, err := alignedBuf(directChunk, 0)
+	if err != nil {
+		return opResult{Context: ctx}, err
+	}
+	defer release()
+	copy(buf, bytes.Repeat([]byte("direct-io "), directChunk/10))
+
+	bf, err := os.Create(bufferedPath)
+	if err != nil {
+		return opResult{Context: ctx}, err
+	}
+	bw, br, err := timedChunks(bf, buf)
+	bf.Close()
+	if err != nil {
+		return opResult{Context: ctx}, fmt.Errorf("buffered: %w", err)
+	}
+	before := fmt.Sprintf("buffered write=%.1f MB/s read=%.1f MB/s", bw, br)
+
+	df, ok, err := openDirect(directPath, os.O_CREATE|os.O_RDWR|os.O_TRUNC)
+	if !ok {
+		if err != nil {
+			return opResult{Before: before, Context: ctx}, err
+		}
+		return opResult{Before: before, Context: ctx, Details: "O_DIRECT not supported, buffered only"}, nil
+	}
+	dw, dr, err := timedChunks(df, buf)
+	df.Close()
+	if err != nil {
+		return opResult{Before: before, Context: ctx}, fmt.Errorf("direct: %w", err)
+	}
+	after := fmt.Sprintf("direct write=%.1f MB/s read=%.1f MB/s", dw, dr)
+	return opResult{Before: before, After: after, Context: ctx, Details: fmt.Sprintf("direct/buffered write=%.2fx read=%.2fx", dw/bw, dr/br)}, nil
+}
+
+func opODirectSeesBuffered(dir string) (opResult, error) {
+	ctx := "buffered write + close, then O_DIRECT read"
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return opResult{Context: ctx}, err
+	}
+	path := filepath.Join(dir, "direct-visibility.bin")
+	defer os.Remove(path)
+
+	want, err := writePatternFile(path, 64<<10)
+	if err != nil {
+		return opResult{Context: ctx}, err
+	}
+	before := fmt.Sprintf("buffered wrote %d bytes", len(want))
+
+	f, ok, err := openDirect(path, os.O_RDONLY)
+	if !ok {
+		if err != nil {
+			return opResult{Before: before, Context: ctx}, err
+		}
+		return opResult{Before: before, Context: ctx, Details: "O_DIRECT not supported"}, nil
+	}
+	defer f.Close()
+
+	buf, release, err := alignedBuf(len(want), 0)
+	if err != nil {
+		return opResult{Before: before, Context: ctx}, err
+	}
+	defer release()
+	n, err := f.ReadAt(buf, 0)
+	if err != nil && n != len(want) {
+		return opResult{Before: before, Context: ctx}, fmt.Errorf("direct read: %w", err)
+	}
+	bad := mismatchedBytes(want, buf[:n])
+	after := fmt.Sprintf("direct read %d bytes, mismatched=%d", n, bad)
+	if bad > 0 {
+		return opResult{Before: before, After: after, Context: ctx}, fmt.Errorf("O_DIRECT read saw stale data in %d bytes", bad)
+	}
+	return opResult{Before: before, After: after, Context: ctx, Details: "O_DIRECT read sees buffered writes after close"}, nil
+}
@@ -0,0 +1,276 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// fallocateOps returns ops for the NFSv4.2 offload operations: ALLOCATE,
+// DEALLOCATE (punch hole), COPY and CLONE. each reports whether the call
+// worked, was emulated, or was refused, rather than failing on EOPNOTSUPP.
+func fallocateOps() []op {
+	return []op{
+		{"fallocate_modes", opFallocateModes},
+		{"copy_file_range", opCopyFileRange},
+		{"ficlone", opFiclone},
+	}
+}
+
+const offloadFileSize = 8 << 20
+
+// unsupported reports whether err means the filesystem or protocol lacks the operation.
+func unsupported(err error) bool {
+	return errors.Is(err, unix.EOPNOTSUPP) || errors.Is(err, unix.ENOSYS) ||
+		errors.Is(err, unix.ENOTTY) || errors.Is(err, unix.EXDEV) || errors.Is(err, unix.EINVAL)
+}
+
+// offloadOutcome labels err as ok, unsupported(ERRNO) or failed(ERRNO).
+func offloadOutcome(err error) string {
+	switch {
+	case err == nil:
+		return "ok"
+	case unsupported(err):
+		return "unsupported(" + errnoName(err) + ")"
+	default:
+		return "failed(" + errnoName(err) + ")"
+	}
+}
+
+// nfsOpDelta names the NFS RPCs in ops whose counters moved between two mountstats snapshots.
+func nfsOpDelta(before, after map[string]int64, ok bool, ops ...string) string {
+	if !ok {
+		return "rpcs n/a (not nfs)"
+	}
+	var parts []string
+	for _, o := range ops {
+		parts = append(parts, fmt.Sprintf("%s=%d", o, after[o]-before[o]))
+	}
+	return "rpcs " + strings.Join(parts, " ")
+}
+
+func writePatternFile(path string, size int) ([]byte, error) {
+	data := make([]byte, size)
+	for i := range data {
+		data[i] = byte(i*7 + i/4096)
+	}
+	return data, os.WriteFile(path, data, 0644)
+}
+
+func opFallocateModes(dir string) (opResult, error) {
+	ctx := "fallocate plain, KEEP_SIZE and PUNCH_HOLE"
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return opResult{Context: ctx}, err
+	}
+	path := filepath.Join(dir, "fallocate.bin")
+	defer os.Remove(path)
+
+	statsBefore, ok := nfsOpCounts(dir)
+	var report []string
+
+	// plain: allocate and extend
+	f, err := os.Create(path)
+	if err != nil {
+		return opResult{Context: ctx}, err
+	}
+	start := time.Now()
+	err = unix.Fallocate(int(f.Fd()), 0, 0, offloadFileSize)
+	elapsed := time.Since(start)
+	info, _ := f.Stat()
+	f.Close()
+	report = append(report, fmt.Sprintf("plain=%s(%s)", offloadOutcome(err), elapsed))
+	if err == nil && info.Size() != offloadFileSize {
+		return opResult{Context: ctx, Details: strings.Join(report, " ")}, fmt.Errorf("plain fallocate: size=%d, want %d", info.Size(), offloadFileSize)
+	}
+
+	// keep_size: allocate past EOF without changing st_size
+	f, err = os.Create(path)
+	if err != nil {
+		return opResult{Context: ctx}, err
+	}
+	start = time.Now()
+	err = unix.Fallocate(int(f.Fd()), unix.FALLOC_FL_KEEP_SIZE, 0, offloadFileSize)
+	elapsed = time.Since(start)
+	info, _ = f.Stat()
+	f.Close()
+	report = append(report, fmt.Sprintf("keep_size=%s(%s, allocated=%d)", offloadOutcome(err), elapsed, allocatedBytes(info)))
+	if err == nil && info.Size() != 0 {
+		return opResult{Context: ctx, Details: strings.Join(report, " ")}, fmt.Errorf("KEEP_SIZE fallocate changed size to %d", info.Size())
+	}
+
+	// punch_hole: deallocate the middle of a written file, which must then read as zeros
+	data, err := writePatternFile(path, offloadFileSize)
+	if err != nil {
+		return opResult{Context: ctx, Details: strings.Join(report, " ")}, err
+	}
+	f, err = os.OpenFile(path, os.O_RDWR, 0644)
+	if err != nil {
+		return opResult{Context: ctx}, err
+	}
+	holeOff, holeLen := int64(1<<20), int64(2<<20)
+	start = time.Now()
+	err = unix.Fallocate(int(f.Fd()), unix.FALLOC_FL_PUNCH_HOLE|unix.FALLOC_FL_KEEP_SIZE, holeOff, holeLen)
+	elapsed = time.Since(start)
+	f.Close()
+	report = append(report, fmt.Sprintf("punch_hole=%s(%s)", offloadOutcome(err), elapsed))
+	if err == nil {
+		got, rerr := os.ReadFile(path)
+		if rerr != nil {
+			return opResult{Context: ctx, Details: strings.Join(report, " ")}, rerr
+		}
+		copy(data[holeOff:holeOff+holeLen], make([]byte, holeLen))
+		if !bytes.Equal(got, data) {
+			return opResult{Context: ctx, Details: strings.Join(report, " ")}, fmt.Errorf("punched file content mismatch (hole not zeroed or data outside hole lost)")
+		}
+	}
+	statsAfter, _ := nfsOpCounts(dir)
+
+	return opResult{
+		Context: ctx,
+		Details: strings.Join(report, " ") + "; " + nfsOpDelta(statsBefore, statsAfter, ok, "ALLOCATE", "DEALLOCATE"),
+	}, nil
+}
+
+func opCopyFileRange(dir string) (opResult, error) {
+	ctx := "copy_file_range vs userspace read+write copy of 8MB"
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return opResult{Context: ctx}, err
+	}
+	src := filepath.Join(dir, "cfr-src.bin")
+	userDst := filepath.Join(dir, "cfr-user.bin")
+	cfrDst := filepath.Join(dir, "cfr-kernel.bin")
+	defer os.Remove(src)
+	defer os.Remove(userDst)
+	defer os.Remove(cfrDst)
+
+	data, err := writePatternFile(src, offloadFileSize)
+	if err != nil {
+		return opResult{Context: ctx}, err
+	}
+
+	// userspace baseline, same approach as opCopyFile
+	start := time.Now()
+	buf, err := os.ReadFile(src)
+	if err == nil {
+		err = os.WriteFile(userDst, buf, 0644)
+	}
+	userTime := time.Since(start)
+	if err != nil {
+		return opResult{Context: ctx}, fmt.Errorf("userspace copy: %w", err)
+	}
+	before := fmt.Sprintf("userspace copy %s", userTime)
+
+	in, err := os.Open(src)
+	if err != nil {
+		return opResult{Before: before, Context: ctx}, err
+	}
+	defer in.Close()
+	out, err := os.Create(cfrDst)
+	if err != nil {
+		return opResult{Before: before, Context: ctx}, err
+	}
+	defer out.Close()
+
+	statsBefore, ok := nfsOpCounts(dir)
+	start = time.Now()
+	var copied int
+	var cfrErr error
+	for copied < offloadFileSize {
+		n, err := unix.CopyFileRange(int(in.Fd()), nil, int(out.Fd()), nil, offloadFileSize-copied, 0)
+		if err != nil {
+			cfrErr = err
+			break
+		}
+		if n == 0 {
+			break
+		}
+		copied += n
+	}
+	cfrTime := time.Since(start)
+	statsAfter, _ := nfsOpCounts(dir)
+
+	outcome := offloadOutcome(cfrErr)
+	if cfrErr != nil && unsupported(cfrErr) {
+		// fall back the way callers like io.Copy would
+		in.Seek(int64(copied), io.SeekStart)
+		out.Seek(int64(copied), io.SeekStart)
+		if _, err := io.Copy(out, in); err != nil {
+			return opResult{Before: before, Context: ctx}, fmt.Errorf("fallback copy: %w", err)
+		}
+		outcome += ", fell back to io.Copy"
+	} else if cfrErr != nil {
+		return opResult{Before: before, Context: ctx, Details: outcome}, fmt.Errorf("copy_file_range: %w", cfrErr)
+	}
+
+	got, err := os.ReadFile(cfrDst)
+	if err != nil {
+		return opResult{Before: before, Context: ctx}, err
+	}
+	if !bytes.Equal(got, data) {
+		return opResult{Before: before, Context: ctx, Details: outcome}, fmt.Errorf("copy content mismatch: got %d bytes", len(got))
+	}
+
+	after := fmt.Sprintf("copy_file_range %s (%.1fx userspace)", cfrTime, float64(userTime)/float64(cfrTime))
+	return opResult{
+		Before:  before,
+		After:   after,
+		Context: ctx,
+		Details: fmt.Sprintf("copy_file_range=%s copied=%d; %s", outcome, copied, nfsOpDelta(statsBefore, statsAfter, ok, "COPY", "READ", "WRITE")),
+	}, nil
+}
+
+func opFiclone(dir string) (opResult, error) {
+	ctx := "ioctl FICLONE reflink copy"
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return opResult{Context: ctx}, err
+	}
+	src := filepath.Join(dir, "clone-src.bin")
+	dst := filepath.Join(dir, "clone-dst.bin")
+	defer os.Remove(src)
+	defer os.Remove(dst)
+
+	data, err := writePatternFile(src, offloadFileSize)
+	if err != nil {
+		return opResult{Context: ctx}, err
+	}
+	in, err := os.Open(src)
+	if err != nil {
+		return opResult{Context: ctx}, err
+	}
+	defer in.Close()
+	out, err := os.Create(dst)
+	if err != nil {
+		return opResult{Context: ctx}, err
+	}
+	defer out.Close()
+
+	statsBefore, ok := nfsOpCounts(dir)
+	start := time.Now()
+	cloneErr := unix.IoctlFileClone(int(out.Fd()), int(in.Fd()))
+	elapsed := time.Since(start)
+	statsAfter, _ := nfsOpCounts(dir)
+
+	details := fmt.Sprintf("FICLONE=%s(%s); %s", offloadOutcome(cloneErr), elapsed, nfsOpDelta(statsBefore, statsAfter, ok, "CLONE"))
+	if cloneErr != nil {
+		if unsupported(cloneErr) {
+			return opResult{Context: ctx, Details: details}, nil
+		}
+		return opResult{Context: ctx, Details: details}, fmt.Errorf("FICLONE: %w", cloneErr)
+	}
+
+	got, err := os.ReadFile(dst)
+	if err != nil {
+		return opResult{Context: ctx, Details: details}, err
+	}
+	if !bytes.Equal(got, data) {
+		return opResult{Context: ctx, Details: details}, fmt.Errorf("cloned content mismatch: got %d bytes", len(got))
+	}
+	return opResult{Context: ctx, After: fmt.Sprintf("clone-dst.bin size=%d", len(got)), Details: details}, nil
+}
@@ -0,0 +1,296 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+// filenameOps returns ops covering unusual file names: unicode normalization,
+// length limits, special characters and case sensitivity.
+func filenameOps() []op {
+	return []op{
+		{"unicode_names", opUnicodeNames},
+		{"name_max", opNameMax},
+		{"path_max", opPathMax},
+		{"special_char_names", opSpecialCharNames},
+		{"case_only_names", opCaseOnlyNames},
+	}
+}
+
+// roundTripName creates name in dir, then lists, stats, renames away and back, and deletes it,
+// returning the first step whose result does not match the name byte-for-byte.
+func roundTripName(dir, name string) error {
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, []byte(name), 0644); err != nil {
+		return fmt.Errorf("create: %s", errnoName(err))
+	}
+	defer os.Remove(path)
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return fmt.Errorf("readdir: %w", err)
+	}
+	found := false
+	var listed []string
+	for _, e := range entries {
+		if e.Name() == name {
+			found = true
+		}
+		listed = append(listed, fmt.Sprintf("%q", e.Name()))
+	}
+	if !found {
+		return fmt.Errorf("readdir did not return %q (got %s)", name, strings.Join(listed, ","))
+	}
+	if _, err := os.Stat(path); err != nil {
+		return fmt.Errorf("stat: %s", errnoName(err))
+	}
+
+	tmp := filepath.Join(dir, "name-roundtrip.tmp")
+	if err := os.Rename(path, tmp); err != nil {
+		return fmt.Errorf("rename away: %s", errnoName(err))
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("rename back: %s", errnoName(err))
+	}
+	data, err := os.ReadFile(path)
+	if err != nil || string(data) != name {
+		return fmt.Errorf("content after rename: %q (%v)", string(data), err)
+	}
+
+	if err := os.Remove(path); err != nil {
+		return fmt.Errorf("delete: %s", errnoName(err))
+	}
+	if _, err := os.Lstat(path); !os.IsNotExist(err) {
+		return fmt.Errorf("still present after delete")
+	}
+	return nil
+}
+
+// roundTripNames runs roundTripName for each labelled name in a fresh subdir.
+func roundTripNames(dir, subdir string, names [][2]string) (ok, failed []string, err error) {
+	d := filepath.Join(dir, subdir)
+	if err := os.MkdirAll(d, 0755); err != nil {
+		return nil, nil, err
+	}
+	defer os.RemoveAll(d)
+	for _, n := range names {
+		if err := roundTripName(d, n[1]); err != nil {
+			failed = append(failed, fmt.Sprintf("%s(%v)", n[0], err))
+		} else {
+			ok = append(ok, n[0])
+		}
+	}
+	return ok, failed, nil
+}
+
+func opUnicodeNames(dir string) (opResult, error) {
+	ctx := "NFC vs NFD, emoji and CJK names; detect server normalization"
+	const (
+		nfc = "caf\u00e9.txt"
+		nfd = "cafe\u0301.txt"
+	)
+	ok, failed, err := roundTripNames(dir, "names-unicode", [][2]string{
+		{"nfc", nfc},
+		{"nfd", nfd},
+		{"emoji", "\U0001F600-\U0001F680.txt"},
+		{"cjk", "日本語.txt"},
+		{"rtl", "שלום.txt"},
+		{"zwj", "a\u200db.txt"},
+	})
+	if err != nil {
+		return opResult{Context: ctx}, err
+	}
+
+	// normalization probe: create NFC, look it up by NFD and try to create NFD alongside it
+	d := filepath.Join(dir, "names-normalize")
+	if err := os.MkdirAll(d, 0755); err != nil {
+		return opResult{Context: ctx}, err
+	}
+	defer os.RemoveAll(d)
+	if err := os.WriteFile(filepath.Join(d, nfc), []byte("nfc"), 0644); err != nil {
+		return opResult{Context: ctx}, err
+	}
+	_, lookupErr := os.Stat(filepath.Join(d, nfd))
+	f, createErr := os.OpenFile(filepath.Join(d, nfd), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if createErr == nil {
+		f.Close()
+	}
+	entries, _ := os.ReadDir(d)
+
+	normalization := "none (NFC and NFD are distinct names)"
+	switch {
+	case lookupErr == nil || errors.Is(createErr, os.ErrExist):
+		normalization = "normalization-insensitive lookup (NFD finds NFC file)"
+	case len(entries) != 2:
+		normalization = fmt.Sprintf("unexpected: %d entries after creating NFC and NFD", len(entries))
+	}
+
+	details := fmt.Sprintf("round-trip ok: %s; normalization: %s", strings.Join(ok, ","), normalization)
+	if len(failed) > 0 {
+		return opResult{Context: ctx, Details: details}, fmt.Errorf("names did not round-trip: %s", strings.Join(failed, "; "))
+	}
+	return opResult{Context: ctx, Details: details}, nil
+}
+
+func opNameMax(dir string) (opResult, error) {
+	ctx := "names of exactly NAME_MAX (255 bytes) and one byte over"
+	d := filepath.Join(dir, "names-max")
+	if err := os.MkdirAll(d, 0755); err != nil {
+		return opResult{Context: ctx}, err
+	}
+	defer os.RemoveAll(d)
+
+	ascii255 := strings.Repeat("n", 255)
+	// 85 three-byte runes = 255 bytes but only 85 characters
+	multibyte255 := strings.Repeat("€", 85)
+
+	var report []string
+	for _, n := range [][2]string{{"ascii255", ascii255}, {"utf8_255", multibyte255}} {
+		if err := roundTripName(d, n[1]); err != nil {
+			return opResult{Context: ctx, Details: strings.Join(report, " ")}, fmt.Errorf("%s: %v", n[0], err)
+		}
+		report = append(report, n[0]+"=ok")
+	}
+
+	over := filepath.Join(d, ascii255+"n")
+	err := os.WriteFile(over, nil, 0644)
+	if err == nil {
+		os.Remove(over)
+		report = append(report, "ascii256=accepted")
+		return opResult{Context: ctx, Details: strings.Join(report, " ")}, fmt.Errorf("256-byte name accepted, NAME_MAX larger than 255")
+	}
+	report = append(report, "ascii256="+errnoName(err))
+	if !errors.Is(err, syscall.ENAMETOOLONG) {
+		return opResult{Context: ctx, Details: strings.Join(report, " ")}, fmt.Errorf("256-byte name: want ENAMETOOLONG, got %v", err)
+	}
+	return opResult{Context: ctx, Details: strings.Join(report, " ")}, nil
+}
+
+func opPathMax(dir string) (opResult, error) {
+	ctx := "nest 200-byte dirs until the path nears PATH_MAX (4096)"
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return opResult{Context: ctx}, err
+	}
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return opResult{Context: ctx}, err
+	}
+	root := filepath.Join(abs, "names-pathmax")
+	defer os.RemoveAll(root)
+
+	component := strings.Repeat("p", 200)
+	path := root
+	for len(path)+len(component)+1 < 4000 {
+		path = filepath.Join(path, component)
+	}
+
+	// fill up to just under 4096 including the terminating NUL. the leaf, and
+	// the over-long name below, must stay under NAME_MAX (255) so that only
+	// PATH_MAX is under test; past 200 bytes one more short dir takes the rest
+	leafLen := 4095 - len(path) - 1
+	if leafLen > 200 {
+		path = filepath.Join(path, strings.Repeat("q", leafLen-100-1))
+		leafLen = 100
+	}
+	if err := os.MkdirAll(path, 0755); err != nil {
+		return opResult{Context: ctx}, fmt.Errorf("mkdir %d-byte path: %s", len(path), errnoName(err))
+	}
+	leaf := strings.Repeat("f", leafLen)
+	file := filepath.Join(path, leaf)
+	if err := os.WriteFile(file, []byte("deep"), 0644); err != nil {
+		return opResult{Context: ctx}, fmt.Errorf("create %d-byte path: %s", len(file), errnoName(err))
+	}
+	data, err := os.ReadFile(file)
+	if err != nil || string(data) != "deep" {
+		return opResult{Context: ctx}, fmt.Errorf("read %d-byte path: %q %v", len(file), string(data), err)
+	}
+	before := fmt.Sprintf("created %d-byte path", len(file))
+
+	over := file + strings.Repeat("x", 8)
+	overErr := os.WriteFile(over, nil, 0644)
+	if overErr == nil {
+		os.Remove(over)
+	}
+	observed := "accepted"
+	if overErr != nil {
+		observed = errnoName(overErr)
+	}
+	after := fmt.Sprintf("%d-byte path: %s", len(over), observed)
+
+	// os.RemoveAll copes with over-long paths by walking relative to each dir
+	if err := os.RemoveAll(root); err != nil {
+		return opResult{Before: before, After: after, Context: ctx}, fmt.Errorf("cleanup deep tree: %w", err)
+	}
+	return opResult{Before: before, After: after, Context: ctx, Details: fmt.Sprintf("path of %d bytes works, %d bytes -> %s", len(file), len(over), observed)}, nil
+}
+
+func opSpecialCharNames(dir string) (opResult, error) {
+	ctx := "names with dashes, spaces, newlines, tabs and shell metacharacters"
+	ok, failed, err := roundTripNames(dir, "names-special", [][2]string{
+		{"leading_dash", "-rf"},
+		{"double_dash", "--help"},
+		{"spaces", "name with spaces.txt"},
+		{"leading_space", " leading"},
+		{"trailing_space", "trailing "},
+		{"trailing_dot", "trailing."},
+		{"newline", "new\nline"},
+		{"tab", "tab\there"},
+		{"backslash", "back\\slash"},
+		{"colon", "c:olon"},
+		{"glob", "star*?[x]"},
+		{"quotes", `'single' "double"`},
+		{"dollar", "$HOME`id`"},
+		{"control", "bell\a\x1b"},
+		{"latin1_byte", "inv\xe9lid"},
+		{"dotdotdot", "..."},
+	})
+	if err != nil {
+		return opResult{Context: ctx}, err
+	}
+	details := fmt.Sprintf("%d/%d round-trip ok", len(ok), len(ok)+len(failed))
+	if len(failed) > 0 {
+		details += "; failed: " + strings.Join(failed, "; ")
+		return opResult{Context: ctx, Details: details}, fmt.Errorf("%d names did not round-trip", len(failed))
+	}
+	return opResult{Context: ctx, Details: details}, nil
+}
+
+func opCaseOnlyNames(dir string) (opResult, error) {
+	ctx := "create Case.txt and case.txt side by side"
+	d := filepath.Join(dir, "names-case")
+	if err := os.MkdirAll(d, 0755); err != nil {
+		return opResult{Context: ctx}, err
+	}
+	defer os.RemoveAll(d)
+
+	upper, lower := filepath.Join(d, "Case.txt"), filepath.Join(d, "case.txt")
+	if err := os.WriteFile(upper, []byte("upper"), 0644); err != nil {
+		return opResult{Context: ctx}, err
+	}
+	_, lookupErr := os.Stat(lower)
+	f, createErr := os.OpenFile(lower, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if createErr == nil {
+		f.WriteString("lower")
+		f.Close()
+	}
+	entries, _ := os.ReadDir(d)
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	after := fmt.Sprintf("entries=%v", names)
+
+	upperData, _ := os.ReadFile(upper)
+	switch {
+	case createErr == nil && len(entries) == 2 && string(upperData) == "upper":
+		return opResult{After: after, Context: ctx, Details: "case-sensitive: both names coexist"}, nil
+	case lookupErr == nil || errors.Is(createErr, os.ErrExist):
+		return opResult{After: after, Context: ctx, Details: "case-insensitive (case folding): case.txt resolves to Case.txt"}, nil
+	}
+	return opResult{After: after, Context: ctx}, fmt.Errorf("inconsistent case handling: create=%v entries=%v upper=%q", createErr, names, string(upperData))
+}
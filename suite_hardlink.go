@@ -0,0 +1,228 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// hardlinkOps returns ops covering link counts and inode number stability.
+func hardlinkOps() []op {
+	return []op{
+		{"hardlink_nlink_chain", opHardlinkNlinkChain},
+		{"inode_stable_rename", opInodeStableRename},
+		{"hardlink_write_visibility", opHardlinkWriteVisibility},
+	}
+}
+
+func statT(path string) (*syscall.Stat_t, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return nil, fmt.Errorf("stat %s: no syscall.Stat_t", path)
+	}
+	return st, nil
+}
+
+func opHardlinkNlinkChain(dir string) (opResult, error) {
+	ctx := "link 3 extra names, verify nlink and inode at each step, then unlink"
+	d := filepath.Join(dir, "nlink-chain")
+	if err := os.MkdirAll(d, 0755); err != nil {
+		return opResult{Context: ctx}, err
+	}
+	defer os.RemoveAll(d)
+
+	orig := filepath.Join(d, "orig.txt")
+	if err := os.WriteFile(orig, []byte("nlink"), 0644); err != nil {
+		return opResult{Context: ctx}, err
+	}
+	st, err := statT(orig)
+	if err != nil {
+		return opResult{Context: ctx}, err
+	}
+	ino := st.Ino
+	before := fmt.Sprintf("orig.txt ino=%d nlink=%d", ino, st.Nlink)
+
+	names := []string{orig}
+	var trace []string
+	check := func(want uint64) error {
+		for _, n := range names {
+			st, err := statT(n)
+			if err != nil {
+				return err
+			}
+			if st.Ino != ino {
+				return fmt.Errorf("%s ino=%d, want %d", filepath.Base(n), st.Ino, ino)
+			}
+			if uint64(st.Nlink) != want {
+				return fmt.Errorf("%s nlink=%d, want %d", filepath.Base(n), st.Nlink, want)
+			}
+		}
+		trace = append(trace, strconv.FormatUint(want, 10))
+		return nil
+	}
+
+	for i := 1; i <= 3; i++ {
+		link := filepath.Join(d, fmt.Sprintf("link-%d.txt", i))
+		if err := os.Link(orig, link); err != nil {
+			return opResult{Before: before, Context: ctx}, err
+		}
+		names = append(names, link)
+		if err := check(uint64(i + 1)); err != nil {
+			return opResult{Before: before, Context: ctx, Details: "nlink " + strings.Join(trace, "->")}, fmt.Errorf("after link %d: %w", i, err)
+		}
+	}
+	// drop the original name first: the remaining links must keep the inode alive
+	for len(names) > 1 {
+		if err := os.Remove(names[0]); err != nil {
+			return opResult{Before: before, Context: ctx}, err
+		}
+		names = names[1:]
+		if err := check(uint64(len(names))); err != nil {
+			return opResult{Before: before, Context: ctx, Details: "nlink " + strings.Join(trace, "->")}, fmt.Errorf("after unlink: %w", err)
+		}
+	}
+
+	return opResult{Before: before, After: fmt.Sprintf("%s ino=%d nlink=1", filepath.Base(names[0]), ino), Context: ctx, Details: "nlink " + strings.Join(trace, "->")}, nil
+}
+
+func opInodeStableRename(dir string) (opResult, error) {
+	ctx := "inode number survives rename in place and across directories"
+	d := filepath.Join(dir, "inode-rename")
+	if err := os.MkdirAll(filepath.Join(d, "other"), 0755); err != nil {
+		return opResult{Context: ctx}, err
+	}
+	defer os.RemoveAll(d)
+
+	path := filepath.Join(d, "a.txt")
+	if err := os.WriteFile(path, []byte("inode"), 0644); err != nil {
+		return opResult{Context: ctx}, err
+	}
+	st, err := statT(path)
+	if err != nil {
+		return opResult{Context: ctx}, err
+	}
+	ino := st.Ino
+	before := fmt.Sprintf("a.txt ino=%d", ino)
+
+	for _, next := range []string{filepath.Join(d, "b.txt"), filepath.Join(d, "other", "c.txt")} {
+		if err := os.Rename(path, next); err != nil {
+			return opResult{Before: before, Context: ctx}, err
+		}
+		path = next
+		st, err := statT(path)
+		if err != nil {
+			return opResult{Before: before, Context: ctx}, err
+		}
+		if st.Ino != ino {
+			return opResult{Before: before, Context: ctx}, fmt.Errorf("%s ino=%d after rename, want %d", filepath.Base(path), st.Ino, ino)
+		}
+	}
+	return opResult{Before: before, After: fmt.Sprintf("other/c.txt ino=%d", ino), Context: ctx, Details: "inode stable across 2 renames"}, nil
+}
+
+func opHardlinkWriteVisibility(dir string) (opResult, error) {
+	ctx := "write through one link, read immediately through the other (fds held open)"
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return opResult{Context: ctx}, err
+	}
+	a := filepath.Join(dir, "hl-vis-a.txt")
+	b := filepath.Join(dir, "hl-vis-b.txt")
+	defer os.Remove(a)
+	defer os.Remove(b)
+
+	if err := os.WriteFile(a, []byte("0000000000"), 0644); err != nil {
+		return opResult{Context: ctx}, err
+	}
+	if err := os.Link(a, b); err != nil {
+		return opResult{Context: ctx}, err
+	}
+	wa, err := os.OpenFile(a, os.O_RDWR, 0644)
+	if err != nil {
+		return opResult{Context: ctx}, err
+	}
+	defer wa.Close()
+	rb, err := os.OpenFile(b, os.O_RDWR, 0644)
+	if err != nil {
+		return opResult{Context: ctx}, err
+	}
+	defer rb.Close()
+
+	var stale []string
+	buf := make([]byte, 10)
+	for i := 1; i <= 5; i++ {
+		want := strings.Repeat(strconv.Itoa(i), 10)
+		// alternate which name is written so both directions are covered
+		w, r := wa, rb
+		if i%2 == 0 {
+			w, r = rb, wa
+		}
+		if _, err := w.WriteAt([]byte(want), 0); err != nil {
+			return opResult{Context: ctx}, err
+		}
+		if _, err := r.ReadAt(buf, 0); err != nil {
+			return opResult{Context: ctx}, err
+		}
+		if string(buf) != want {
+			stale = append(stale, fmt.Sprintf("round %d got %q", i, string(buf)))
+		}
+		byName, _ := os.ReadFile(filepath.Join(dir, filepath.Base(r.Name())))
+		if string(byName) != want {
+			stale = append(stale, fmt.Sprintf("round %d reopen got %q", i, string(byName)))
+		}
+	}
+	if len(stale) > 0 {
+		return opResult{Context: ctx, Details: strings.Join(stale, "; ")}, fmt.Errorf("write via one link not visible via other: %d stale reads", len(stale))
+	}
+	return opResult{Context: ctx, Details: "5 alternating writes immediately visible through both links"}, nil
+}
+
+// opInodeCrossRun keeps a probe file in the shared dir and records its inode number,
+// so each run (often a fresh container and therefore a fresh mount) can check the
+// server still reports the same inode for the same file.
+func opInodeCrossRun(dir string) (opResult, error) {
+	ctx := "inode of persistent probe file compared with previous runs"
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return opResult{Context: ctx}, err
+	}
+	probe := filepath.Join(dir, "inode-probe.txt")
+	record := filepath.Join(dir, "inode-probe.ino")
+
+	if _, err := os.Stat(probe); os.IsNotExist(err) {
+		if err := os.WriteFile(probe, []byte("do not delete: inode stability probe\n"), 0644); err != nil {
+			return opResult{Context: ctx}, err
+		}
+	}
+	st, err := statT(probe)
+	if err != nil {
+		return opResult{Context: ctx}, err
+	}
+	current := fmt.Sprintf("%d:%d", st.Dev, st.Ino)
+
+	prevData, readErr := os.ReadFile(record)
+	previous := strings.TrimSpace(string(prevData))
+	if err := writeFileAtomic(record, []byte(current+"\n"), 0644, false); err != nil {
+		return opResult{Context: ctx}, fmt.Errorf("record inode: %w", err)
+	}
+
+	if readErr != nil {
+		return opResult{After: "dev:ino=" + current, Context: ctx, Details: "no previous record (first run)"}, nil
+	}
+	before := "dev:ino=" + previous
+	after := "dev:ino=" + current
+	prevIno := previous[strings.LastIndex(previous, ":")+1:]
+	if prevIno != strconv.FormatUint(st.Ino, 10) {
+		return opResult{Before: before, After: after, Context: ctx}, fmt.Errorf("probe inode changed since last run: %s -> %d", prevIno, st.Ino)
+	}
+	details := "inode stable since last run"
+	if previous != current {
+		details += " (device number changed: new mount)"
+	}
+	return opResult{Before: before, After: after, Context: ctx, Details: details}, nil
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// largeFileOps returns ops covering 64-bit offsets and sparse files beyond 4GB.
+// nothing here writes more than a few KB: all large sizes come from seeking.
+func largeFileOps() []op {
+	return []op{
+		{"sparse_large_offsets", opSparseLargeOffsets},
+		{"max_file_size", opMaxFileSize},
+		{"seek_data_hole", opSeekDataHole},
+	}
+}
+
+func allocatedBytes(info os.FileInfo) int64 {
+	if st, ok := info.Sys().(*syscall.Stat_t); ok {
+		return st.Blocks * 512
+	}
+	return -1
+}
+
+func opSparseLargeOffsets(dir string) (opResult, error) {
+	ctx := "write past 2GB, 4GB and ~16TB offsets, verify size and tail"
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return opResult{Context: ctx}, err
+	}
+
+	offsets := []struct {
+		name     string
+		off      int64
+		required bool
+	}{
+		{"2GB", 2<<30 + 1, true},
+		{"4GB", 4<<30 + 1, true},
+		{"16TB", 16<<40 - 64<<10, false},
+	}
+
+	var report []string
+	for _, o := range offsets {
+		path := filepath.Join(dir, "sparse-"+o.name+".bin")
+		payload := []byte(fmt.Sprintf("tail at %d", o.off))
+
+		f, err := os.Create(path)
+		if err != nil {
+			return opResult{Context: ctx, Details: strings.Join(report, " ")}, err
+		}
+		_, werr := f.WriteAt(payload, o.off)
+		f.Close()
+		if werr != nil {
+			os.Remove(path)
+			if o.required {
+				return opResult{Context: ctx, Details: strings.Join(report, " ")}, fmt.Errorf("write at %s: %w", o.name, werr)
+			}
+			report = append(report, fmt.Sprintf("%s=rejected(%s)", o.name, errnoName(werr)))
+			continue
+		}
+
+		info, err := os.Stat(path)
+		if err != nil {
+			os.Remove(path)
+			return opResult{Context: ctx}, err
+		}
+		buf := make([]byte, len(payload))
+		rf, err := os.Open(path)
+		if err != nil {
+			os.Remove(path)
+			return opResult{Context: ctx}, err
+		}
+		_, rerr := rf.ReadAt(buf, o.off)
+		rf.Close()
+		os.Remove(path)
+
+		want := o.off + int64(len(payload))
+		if info.Size() != want {
+			return opResult{Context: ctx, Details: strings.Join(report, " ")}, fmt.Errorf("%s: size=%d, want %d", o.name, info.Size(), want)
+		}
+		if rerr != nil || !bytes.Equal(buf, payload) {
+			return opResult{Context: ctx, Details: strings.Join(report, " ")}, fmt.Errorf("%s: tail read mismatch: got %q (%v)", o.name, string(buf), rerr)
+		}
+		report = append(report, fmt.Sprintf("%s=ok(size=%d allocated=%d)", o.name, info.Size(), allocatedBytes(info)))
+	}
+
+	return opResult{Context: ctx, Details: strings.Join(report, " ")}, nil
+}
+
+func opMaxFileSize(dir string) (opResult, error) {
+	ctx := "ftruncate to growing powers of two until the mount refuses"
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return opResult{Context: ctx}, err
+	}
+	path := filepath.Join(dir, "max-size.bin")
+	f, err := os.Create(path)
+	if err != nil {
+		return opResult{Context: ctx}, err
+	}
+	defer os.Remove(path)
+	defer f.Close()
+
+	var largest int64
+	var stopErr error
+	for shift := 31; shift < 63; shift++ {
+		size := int64(1) << shift
+		if err := f.Truncate(size); err != nil {
+			stopErr = err
+			break
+		}
+		largest = size
+	}
+
+	// refine between the last accepted and first refused power of two
+	if stopErr != nil && largest > 0 {
+		lo, hi := largest, largest*2
+		for hi-lo > 4096 {
+			mid := lo + (hi-lo)/2
+			if f.Truncate(mid) == nil {
+				lo = mid
+			} else {
+				hi = mid
+			}
+		}
+		largest = lo
+	}
+	f.Truncate(0)
+
+	if largest < 4<<30 {
+		return opResult{Context: ctx}, fmt.Errorf("max file size %d is below 4GB: %v", largest, stopErr)
+	}
+	limit := "no limit hit below 2^63"
+	if stopErr != nil {
+		limit = "then " + errnoName(stopErr)
+	}
+	return opResult{Context: ctx, Details: fmt.Sprintf("max accepted size=%d (%.1f TiB), %s", largest, float64(largest)/(1<<40), limit)}, nil
+}
+
+func opSeekDataHole(dir string) (opResult, error) {
+	ctx := "SEEK_DATA/SEEK_HOLE on file with data at 0 and 5GB"
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return opResult{Context: ctx}, err
+	}
+	path := filepath.Join(dir, "holes.bin")
+	f, err := os.Create(path)
+	if err != nil {
+		return opResult{Context: ctx}, err
+	}
+	defer os.Remove(path)
+	defer f.Close()
+
+	const farOffset = 5 << 30
+	chunk := bytes.Repeat([]byte("D"), 64<<10)
+	if _, err := f.WriteAt(chunk, 0); err != nil {
+		return opResult{Context: ctx}, err
+	}
+	if _, err := f.WriteAt(chunk, farOffset); err != nil {
+		return opResult{Context: ctx}, err
+	}
+	if err := f.Sync(); err != nil {
+		return opResult{Context: ctx}, err
+	}
+	info, _ := f.Stat()
+	before := fmt.Sprintf("size=%d allocated=%d", info.Size(), allocatedBytes(info))
+
+	fd := int(f.Fd())
+	hole, err := syscall.Seek(fd, 0, unix.SEEK_HOLE)
+	if err != nil {
+		return opResult{Before: before, Context: ctx, Details: "SEEK_HOLE unsupported: " + errnoName(err)}, nil
+	}
+	data, err := syscall.Seek(fd, hole, unix.SEEK_DATA)
+	if err != nil {
+		return opResult{Before: before, Context: ctx, Details: fmt.Sprintf("first hole at %d, SEEK_DATA failed: %s", hole, errnoName(err))}, nil
+	}
+	after := fmt.Sprintf("first hole=%d next data=%d", hole, data)
+
+	if hole >= info.Size() {
+		return opResult{Before: before, After: after, Context: ctx, Details: "no holes reported: sparseness not visible through SEEK_HOLE (server or protocol < 4.2)"}, nil
+	}
+	if data > farOffset || data < int64(len(chunk)) {
+		return opResult{Before: before, After: after, Context: ctx}, fmt.Errorf("SEEK_DATA returned %d, want between %d and %d", data, len(chunk), farOffset)
+	}
+	return opResult{Before: before, After: after, Context: ctx, Details: "holes preserved and reported by SEEK_HOLE/SEEK_DATA"}, nil
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"golang.org/x/sys/unix"
+)
+
+// mmapOps returns ops checking coherence between MAP_SHARED mappings of a file
+// on the mount and regular read/write through file descriptors.
+func mmapOps() []op {
+	return []op{
+		{"mmap_write_read_fd", opMmapWriteReadFd},
+		{"mmap_fd_write_read_map", opMmapFdWriteReadMap},
+		{"mmap_cross_process", opMmapCrossProcess},
+	}
+}
+
+const mmapSize = 64 << 10
+
+func mmapPattern(seed int) []byte {
+	buf := make([]byte, mmapSize)
+	for i := range buf {
+		buf[i] = byte(i*13 + seed)
+	}
+	return buf
+}
+
+// mismatchedBytes counts positions where a and b differ.
+func mismatchedBytes(a, b []byte) int {
+	n := 0
+	for i := range a {
+		if i >= len(b) || a[i] != b[i] {
+			n++
+		}
+	}
+	return n + max(0, len(b)-len(a))
+}
+
+// mapShared maps the whole of f read-write with MAP_SHARED.
+func mapShared(f *os.File) ([]byte, error) {
+	return unix.Mmap(int(f.Fd()), 0, mmapSize, unix.PROT_READ|unix.PROT_WRITE, unix.MAP_SHARED)
+}
+
+// createMmapFile creates a zeroed mmapSize file and returns it opened read-write.
+func createMmapFile(path string) (*os.File, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR|os.O_TRUNC, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if err := f.Truncate(mmapSize); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return f, nil
+}
+
+func opMmapWriteReadFd(dir string) (opResult, error) {
+	ctx := "write via MAP_SHARED mapping + msync, verify with read()"
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return opResult{Context: ctx}, err
+	}
+	path := filepath.Join(dir, "mmap-write.bin")
+	defer os.Remove(path)
+
+	f, err := createMmapFile(path)
+	if err != nil {
+		return opResult{Context: ctx}, err
+	}
+	defer f.Close()
+	m, err := mapShared(f)
+	if err != nil {
+		return opResult{Context: ctx}, fmt.Errorf("mmap: %w", err)
+	}
+	defer unix.Munmap(m)
+
+	want := mmapPattern(1)
+	copy(m, want)
+
+	// before msync a same-client read should already see the page cache
+	early, err := os.ReadFile(path)
+	if err != nil {
+		return opResult{Context: ctx}, err
+	}
+	before := fmt.Sprintf("pre-msync read mismatched=%d", mismatchedBytes(want, early))
+
+	if err := unix.Msync(m, unix.MS_SYNC); err != nil {
+		return opResult{Before: before, Context: ctx}, fmt.Errorf("msync: %w", err)
+	}
+	got, err := os.ReadFile(path)
+	if err != nil {
+		return opResult{Before: before, Context: ctx}, err
+	}
+	bad := mismatchedBytes(want, got)
+	after := fmt.Sprintf("post-msync read mismatched=%d", bad)
+	if bad > 0 {
+		return opResult{Before: before, After: after, Context: ctx}, fmt.Errorf("read() disagrees with mapping in %d bytes after msync", bad)
+	}
+	return opResult{Before: before, After: after, Context: ctx, Details: fmt.Sprintf("%d bytes coherent", mmapSize)}, nil
+}
+
+func opMmapFdWriteReadMap(dir string) (opResult, error) {
+	ctx := "write via fd, verify through existing MAP_SHARED mapping"
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return opResult{Context: ctx}, err
+	}
+	path := filepath.Join(dir, "mmap-fdwrite.bin")
+	defer os.Remove(path)
+
+	f, err := createMmapFile(path)
+	if err != nil {
+		return opResult{Context: ctx}, err
+	}
+	defer f.Close()
+	m, err := mapShared(f)
+	if err != nil {
+		return opResult{Context: ctx}, fmt.Errorf("mmap: %w", err)
+	}
+	defer unix.Munmap(m)
+	// fault the pages in so the mapping holds the old (zero) contents
+	before := fmt.Sprintf("mapping nonzero bytes=%d", mismatchedBytes(make([]byte, mmapSize), m))
+
+	want := mmapPattern(2)
+	w, err := os.OpenFile(path, os.O_WRONLY, 0644)
+	if err != nil {
+		return opResult{Before: before, Context: ctx}, err
+	}
+	if _, err := w.WriteAt(want, 0); err != nil {
+		w.Close()
+		return opResult{Before: before, Context: ctx}, err
+	}
+	w.Close()
+
+	bad := mismatchedBytes(want, m)
+	after := fmt.Sprintf("mapping mismatched=%d", bad)
+	if bad > 0 {
+		return opResult{Before: before, After: after, Context: ctx}, fmt.Errorf("mapping stale in %d bytes after fd write", bad)
+	}
+	return opResult{Before: before, After: after, Context: ctx, Details: "fd write visible through mapping"}, nil
+}
+
+// childMmap is the child-process side of mmap_cross_process: args are path, mode, seed.
+// mode "write" stores the pattern through a shared mapping and msyncs it;
+// mode "verify" checks the file via read() and its own mapping.
+func childMmap(args []string) error {
+	if len(args) != 3 {
+		return fmt.Errorf("usage: <path> write|verify <seed>")
+	}
+	seed, err := strconv.Atoi(args[2])
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(args[0], os.O_RDWR, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	m, err := mapShared(f)
+	if err != nil {
+		return fmt.Errorf("mmap: %w", err)
+	}
+	defer unix.Munmap(m)
+
+	want := mmapPattern(seed)
+	switch args[1] {
+	case "write":
+		copy(m, want)
+		return unix.Msync(m, unix.MS_SYNC)
+	case "verify":
+		got, err := os.ReadFile(args[0])
+		if err != nil {
+			return err
+		}
+		if bad := mismatchedBytes(want, got); bad > 0 {
+			return fmt.Errorf("read() mismatched %d bytes", bad)
+		}
+		if bad := mismatchedBytes(want, m); bad > 0 {
+			return fmt.Errorf("mapping mismatched %d bytes", bad)
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown mode %q", args[1])
+}
+
+func opMmapCrossProcess(dir string) (opResult, error) {
+	ctx := "child process writes via mmap, parent checks mapping + read(), and back"
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return opResult{Context: ctx}, err
+	}
+	path := filepath.Join(dir, "mmap-xproc.bin")
+	defer os.Remove(path)
+
+	f, err := createMmapFile(path)
+	if err != nil {
+		return opResult{Context: ctx}, err
+	}
+	defer f.Close()
+	m, err := mapShared(f)
+	if err != nil {
+		return opResult{Context: ctx}, fmt.Errorf("mmap: %w", err)
+	}
+	defer unix.Munmap(m)
+
+	// child -> parent
+	if err := runChildren("mmap", [][]string{{path, "write", "3"}}); err != nil {
+		return opResult{Context: ctx}, fmt.Errorf("child write: %w", err)
+	}
+	want := mmapPattern(3)
+	got, err := os.ReadFile(path)
+	if err != nil {
+		return opResult{Context: ctx}, err
+	}
+	readBad, mapBad := mismatchedBytes(want, got), mismatchedBytes(want, m)
+	before := fmt.Sprintf("child->parent mismatched read=%d map=%d", readBad, mapBad)
+	if readBad > 0 || mapBad > 0 {
+		return opResult{Before: before, Context: ctx}, fmt.Errorf("parent sees stale data after child mmap write: read=%d map=%d bytes", readBad, mapBad)
+	}
+
+	// parent -> child
+	copy(m, mmapPattern(4))
+	if err := unix.Msync(m, unix.MS_SYNC); err != nil {
+		return opResult{Before: before, Context: ctx}, fmt.Errorf("msync: %w", err)
+	}
+	if err := runChildren("mmap", [][]string{{path, "verify", "4"}}); err != nil {
+		return opResult{Before: before, Context: ctx}, fmt.Errorf("child verify: %w", err)
+	}
+	return opResult{Before: before, After: "parent->child verified", Context: ctx, Details: "mappings coherent across processes"}, nil
+}
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// renameOps returns ops covering atomic replace semantics, which the session
+// store and image uploads rely on.
+func renameOps() []op {
+	return []op{
+		{"rename_over_existing", opRenameOverExisting},
+		{"atomic_replace_fsync", opAtomicReplaceFsync},
+		{"rename_concurrent_readers", opRenameConcurrentReaders},
+	}
+}
+
+func inodeOf(path string) (uint64, error) {
+	st, err := statT(path)
+	if err != nil {
+		return 0, err
+	}
+	return st.Ino, nil
+}
+
+func opRenameOverExisting(dir string) (opResult, error) {
+	ctx := "os.Rename onto an existing target"
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return opResult{Context: ctx}, err
+	}
+	target := filepath.Join(dir, "replace-target.txt")
+	src := filepath.Join(dir, "replace-src.txt")
+	defer os.Remove(target)
+	defer os.Remove(src)
+
+	if err := os.WriteFile(target, []byte("old version"), 0644); err != nil {
+		return opResult{Context: ctx}, err
+	}
+	if err := os.WriteFile(src, []byte("new version"), 0644); err != nil {
+		return opResult{Context: ctx}, err
+	}
+	oldIno, _ := inodeOf(target)
+	newIno, _ := inodeOf(src)
+	before := fmt.Sprintf("target ino=%d content=%q, src ino=%d", oldIno, "old version", newIno)
+
+	if err := os.Rename(src, target); err != nil {
+		return opResult{Before: before, Context: ctx}, err
+	}
+
+	data, err := os.ReadFile(target)
+	if err != nil {
+		return opResult{Before: before, Context: ctx}, fmt.Errorf("read target: %w", err)
+	}
+	gotIno, _ := inodeOf(target)
+	_, srcErr := os.Stat(src)
+	after := fmt.Sprintf("target ino=%d content=%q, src exists=%v", gotIno, string(data), srcErr == nil)
+
+	if string(data) != "new version" {
+		return opResult{Before: before, After: after, Context: ctx}, fmt.Errorf("target content after rename: got %q", string(data))
+	}
+	if srcErr == nil {
+		return opResult{Before: before, After: after, Context: ctx}, fmt.Errorf("source still exists after rename")
+	}
+	if gotIno != newIno {
+		return opResult{Before: before, After: after, Context: ctx}, fmt.Errorf("target inode %d, want source inode %d", gotIno, newIno)
+	}
+	return opResult{Before: before, After: after, Context: ctx, Details: "target replaced, inode follows source"}, nil
+}
+
+func opAtomicReplaceFsync(dir string) (opResult, error) {
+	ctx := "temp file + fsync + rename + dir fsync, timed per step"
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return opResult{Context: ctx}, err
+	}
+	target := filepath.Join(dir, "durable.json")
+	defer os.Remove(target)
+	if err := os.WriteFile(target, []byte(`{"version":1}`), 0644); err != nil {
+		return opResult{Context: ctx}, err
+	}
+	before := `content={"version":1}`
+
+	payload := []byte(`{"version":2}`)
+	var steps []string
+	timed := func(name string, fn func() error) error {
+		start := time.Now()
+		err := fn()
+		steps = append(steps, fmt.Sprintf("%s=%s", name, time.Since(start)))
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		return nil
+	}
+
+	var tmp *os.File
+	err := timed("create", func() (err error) {
+		tmp, err = os.CreateTemp(dir, ".durable.json.tmp-*")
+		return err
+	})
+	if err != nil {
+		return opResult{Before: before, Context: ctx}, err
+	}
+	tmpName := tmp.Name()
+	defer os.Remove(tmpName)
+
+	for _, s := range []struct {
+		name string
+		fn   func() error
+	}{
+		{"write", func() error { _, err := tmp.Write(payload); return err }},
+		{"fsync", tmp.Sync},
+		{"close", tmp.Close},
+		{"rename", func() error { return os.Rename(tmpName, target) }},
+		{"dir_fsync", func() error { return syncDir(dir) }},
+	} {
+		if err := timed(s.name, s.fn); err != nil {
+			tmp.Close()
+			return opResult{Before: before, Context: ctx, Details: strings.Join(steps, " ")}, err
+		}
+	}
+
+	data, err := os.ReadFile(target)
+	if err != nil {
+		return opResult{Before: before, Context: ctx}, fmt.Errorf("read back: %w", err)
+	}
+	after := fmt.Sprintf("content=%s", data)
+	if !bytes.Equal(data, payload) {
+		return opResult{Before: before, After: after, Context: ctx}, fmt.Errorf("content mismatch after replace: got %q", string(data))
+	}
+	if _, err := os.Stat(tmpName); err == nil {
+		return opResult{Before: before, After: after, Context: ctx}, fmt.Errorf("temp file %s left behind", filepath.Base(tmpName))
+	}
+
+	// the same sequence through the shared helper, as the session store uses it
+	const rounds = 10
+	start := time.Now()
+	for i := 0; i < rounds; i++ {
+		if err := writeFileAtomic(target, []byte(fmt.Sprintf(`{"version":%d}`, i+3)), 0644, true); err != nil {
+			return opResult{Before: before, After: after, Context: ctx}, fmt.Errorf("writeFileAtomic round %d: %w", i, err)
+		}
+	}
+	avg := time.Since(start) / rounds
+
+	return opResult{Before: before, After: after, Context: ctx, Details: fmt.Sprintf("%s, writeFileAtomic avg=%s over %d rounds", strings.Join(steps, " "), avg, rounds)}, nil
+}
+
+// renamePayload builds a fixed-size payload whose body is derived from gen,
+// so readers can tell a complete old/new version from a torn one.
+func renamePayload(gen, size int) []byte {
+	header := fmt.Sprintf("gen=%08d\n", gen)
+	buf := bytes.Repeat([]byte{byte('a' + gen%26)}, size)
+	copy(buf, header)
+	return buf
+}
+
+func validRenamePayload(data []byte, size int) bool {
+	if len(data) != size {
+		return false
+	}
+	var gen int
+	if _, err := fmt.Sscanf(string(data[:13]), "gen=%08d\n", &gen); err != nil {
+		return false
+	}
+	return bytes.Equal(data, renamePayload(gen, size))
+}
+
+func opRenameConcurrentReaders(dir string) (opResult, error) {
+	ctx := "replace file via rename while 4 readers loop on it"
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return opResult{Context: ctx}, err
+	}
+	target := filepath.Join(dir, "hot-swap.bin")
+	defer os.Remove(target)
+
+	const (
+		size    = 64 * 1024
+		readers = 4
+		rounds  = 200
+	)
+	if err := os.WriteFile(target, renamePayload(0, size), 0644); err != nil {
+		return opResult{Context: ctx}, err
+	}
+	before := fmt.Sprintf("hot-swap.bin gen=0 size=%d", size)
+
+	var (
+		reads, enoent, torn, other atomic.Int64
+		worstGap                   atomic.Int64
+		stop                       = make(chan struct{})
+		wg                         sync.WaitGroup
+		firstOther                 atomic.Value
+	)
+	for r := 0; r < readers; r++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var gapStart time.Time
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				data, err := os.ReadFile(target)
+				reads.Add(1)
+				switch {
+				case os.IsNotExist(err):
+					enoent.Add(1)
+					if gapStart.IsZero() {
+						gapStart = time.Now()
+					}
+					continue
+				case err != nil:
+					other.Add(1)
+					firstOther.CompareAndSwap(nil, err.Error())
+				case !validRenamePayload(data, size):
+					torn.Add(1)
+				}
+				if !gapStart.IsZero() {
+					if gap := int64(time.Since(gapStart)); gap > worstGap.Load() {
+						worstGap.Store(gap)
+					}
+					gapStart = time.Time{}
+				}
+			}
+		}()
+	}
+
+	start := time.Now()
+	var writeErr error
+	for gen := 1; gen <= rounds; gen++ {
+		if err := writeFileAtomic(target, renamePayload(gen, size), 0644, false); err != nil {
+			writeErr = fmt.Errorf("replace gen %d: %w", gen, err)
+			break
+		}
+	}
+	elapsed := time.Since(start)
+	close(stop)
+	wg.Wait()
+
+	after := fmt.Sprintf("%d replaces in %s, %d reads", rounds, elapsed, reads.Load())
+	details := fmt.Sprintf("reads=%d torn=%d enoent=%d other_errors=%d worst_enoent_window=%s",
+		reads.Load(), torn.Load(), enoent.Load(), other.Load(), time.Duration(worstGap.Load()))
+	if writeErr != nil {
+		return opResult{Before: before, After: after, Context: ctx, Details: details}, writeErr
+	}
+	if torn.Load() > 0 || enoent.Load() > 0 {
+		return opResult{Before: before, After: after, Context: ctx, Details: details}, fmt.Errorf("rename not atomic for readers: %d torn, %d ENOENT", torn.Load(), enoent.Load())
+	}
+	if other.Load() > 0 {
+		return opResult{Before: before, After: after, Context: ctx, Details: details}, fmt.Errorf("reader errors: %v", firstOther.Load())
+	}
+	return opResult{Before: before, After: after, Context: ctx, Details: details}, nil
+}
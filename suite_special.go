@@ -0,0 +1,331 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+// specialOps returns ops for non-regular files: sockets, device nodes and
+// symlink edge cases. most report expected vs observed semantics rather than
+// failing, since privilege and export options legitimately change the outcome.
+func specialOps() []op {
+	return []op{
+		{"unix_socket_file", opUnixSocketFile},
+		{"mknod_devices", opMknodDevices},
+		{"dangling_symlink", opDanglingSymlink},
+		{"symlink_loop", opSymlinkLoop},
+		{"symlink_escape", opSymlinkEscape},
+		{"lchown_lstat_link", opLchownLstatLink},
+	}
+}
+
+// sunPathMax is the usable length of sockaddr_un.sun_path on linux.
+const sunPathMax = 107
+
+// semantics labels an expected vs observed outcome.
+func semantics(name, expected, observed string) string {
+	verdict := "match"
+	if expected != observed {
+		verdict = "MISMATCH"
+	}
+	return fmt.Sprintf("%s: expected=%s observed=%s (%s)", name, expected, observed, verdict)
+}
+
+func outcome(err error) string {
+	if err == nil {
+		return "ok"
+	}
+	return errnoName(err)
+}
+
+func opUnixSocketFile(dir string) (opResult, error) {
+	ctx := "bind a unix domain socket on the mount (mknod S_IFSOCK if path too long)"
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return opResult{Context: ctx}, err
+	}
+	path := filepath.Join(dir, "test.sock")
+	os.Remove(path)
+	defer os.Remove(path)
+
+	method := "bind"
+	var roundTrip string
+	if len(path) <= sunPathMax {
+		ln, err := net.Listen("unix", path)
+		if err != nil {
+			return opResult{Context: ctx, Details: semantics("bind", "ok", outcome(err))}, nil
+		}
+		defer ln.Close()
+		go func() {
+			if c, err := ln.Accept(); err == nil {
+				c.Write([]byte("pong"))
+				c.Close()
+			}
+		}()
+		// a same-host connect works even on NFS: the socket lives in the local kernel
+		c, err := net.Dial("unix", path)
+		if err != nil {
+			roundTrip = "connect " + errnoName(err)
+		} else {
+			buf := make([]byte, 4)
+			n, _ := c.Read(buf)
+			c.Close()
+			roundTrip = fmt.Sprintf("connect ok, read %q", string(buf[:n]))
+		}
+	} else {
+		method = "mknod"
+		if err := syscall.Mknod(path, syscall.S_IFSOCK|0644, 0); err != nil {
+			return opResult{Context: ctx, Details: semantics("mknod S_IFSOCK", "ok", outcome(err))}, nil
+		}
+		roundTrip = fmt.Sprintf("path %d bytes > sun_path, connect not attempted", len(path))
+	}
+
+	info, err := os.Lstat(path)
+	if err != nil {
+		return opResult{Context: ctx}, fmt.Errorf("lstat socket: %w", err)
+	}
+	after := fmt.Sprintf("test.sock mode=%s", info.Mode())
+	if info.Mode()&os.ModeSocket == 0 {
+		return opResult{After: after, Context: ctx}, fmt.Errorf("%s created non-socket mode %s", method, info.Mode())
+	}
+	return opResult{After: after, Context: ctx, Details: fmt.Sprintf("created via %s, %s", method, roundTrip)}, nil
+}
+
+func opMknodDevices(dir string) (opResult, error) {
+	ctx := "mknod char (1,3) and block (7,0) devices"
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return opResult{Context: ctx}, err
+	}
+
+	// CAP_MKNOD is normally root only; root_squash/all_squash exports still say EPERM
+	expected := "EPERM"
+	if os.Geteuid() == 0 {
+		expected = "ok"
+	}
+
+	devices := []struct {
+		name string
+		mode uint32
+		dev  int
+		flag os.FileMode
+	}{
+		{"char", syscall.S_IFCHR, 1<<8 | 3, os.ModeCharDevice},
+		{"block", syscall.S_IFBLK, 7 << 8, os.ModeDevice},
+	}
+
+	var report []string
+	for _, d := range devices {
+		path := filepath.Join(dir, "test-"+d.name+".dev")
+		os.Remove(path)
+		err := syscall.Mknod(path, d.mode|0644, d.dev)
+		observed := outcome(err)
+		if err == nil {
+			info, lerr := os.Lstat(path)
+			st, serr := statT(path)
+			switch {
+			case lerr != nil:
+				observed = "lstat " + errnoName(lerr)
+			case info.Mode()&d.flag == 0:
+				observed = fmt.Sprintf("wrong mode %s", info.Mode())
+			case serr == nil && st.Rdev != uint64(d.dev):
+				observed = fmt.Sprintf("rdev %#x want %#x", st.Rdev, d.dev)
+			}
+			os.Remove(path)
+		}
+		report = append(report, semantics(d.name, expected, observed))
+	}
+	return opResult{Context: ctx, Details: strings.Join(report, "; ")}, nil
+}
+
+func opDanglingSymlink(dir string) (opResult, error) {
+	ctx := "symlink to a missing target: Lstat ok, Stat ENOENT, Readlink verbatim"
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return opResult{Context: ctx}, err
+	}
+	link := filepath.Join(dir, "dangling-link")
+	target := "does-not-exist.txt"
+	os.Remove(link)
+	defer os.Remove(link)
+
+	if err := os.Symlink(target, link); err != nil {
+		return opResult{Context: ctx}, err
+	}
+	_, lstatErr := os.Lstat(link)
+	_, statErr := os.Stat(link)
+	got, readErr := os.Readlink(link)
+
+	report := []string{
+		semantics("lstat", "ok", outcome(lstatErr)),
+		semantics("stat", "ENOENT", outcome(statErr)),
+		semantics("readlink", target, got),
+	}
+	details := strings.Join(report, "; ")
+	if lstatErr != nil || !errors.Is(statErr, syscall.ENOENT) || readErr != nil || got != target {
+		return opResult{Context: ctx, Details: details}, fmt.Errorf("dangling symlink semantics differ")
+	}
+	return opResult{Context: ctx, Details: details}, nil
+}
+
+func opSymlinkLoop(dir string) (opResult, error) {
+	ctx := "loop-a -> loop-b -> loop-a and a self loop: expect ELOOP"
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return opResult{Context: ctx}, err
+	}
+	a := filepath.Join(dir, "loop-a")
+	b := filepath.Join(dir, "loop-b")
+	self := filepath.Join(dir, "loop-self")
+	for _, p := range []string{a, b, self} {
+		os.Remove(p)
+		defer os.Remove(p)
+	}
+
+	if err := os.Symlink("loop-b", a); err != nil {
+		return opResult{Context: ctx}, err
+	}
+	if err := os.Symlink("loop-a", b); err != nil {
+		return opResult{Context: ctx}, err
+	}
+	if err := os.Symlink("loop-self", self); err != nil {
+		return opResult{Context: ctx}, err
+	}
+
+	_, statErr := os.Stat(a)
+	f, openErr := os.Open(self)
+	if openErr == nil {
+		f.Close()
+	}
+	report := []string{
+		semantics("stat loop-a", "ELOOP", outcome(statErr)),
+		semantics("open loop-self", "ELOOP", outcome(openErr)),
+	}
+	details := strings.Join(report, "; ")
+	if !errors.Is(statErr, syscall.ELOOP) || !errors.Is(openErr, syscall.ELOOP) {
+		return opResult{Context: ctx, Details: details}, fmt.Errorf("symlink loop not detected as ELOOP")
+	}
+	return opResult{Context: ctx, Details: details}, nil
+}
+
+func opSymlinkEscape(dir string) (opResult, error) {
+	ctx := "relative and absolute symlink targets pointing outside the mount"
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return opResult{Context: ctx}, err
+	}
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return opResult{Context: ctx}, err
+	}
+
+	// enough ../ to climb out of any mount to the client's root
+	relTarget := strings.Repeat("../", strings.Count(abs, "/")+2) + "etc/hostname"
+	links := []struct {
+		name, target string
+	}{
+		{"escape-relative", relTarget},
+		{"escape-absolute", "/etc/hostname"},
+	}
+
+	var report []string
+	for _, l := range links {
+		path := filepath.Join(dir, l.name)
+		os.Remove(path)
+		if err := os.Symlink(l.target, path); err != nil {
+			return opResult{Context: ctx}, fmt.Errorf("%s: %w", l.name, err)
+		}
+		got, _ := os.Readlink(path)
+		_, statErr := os.Stat(path)
+		os.Remove(path)
+
+		// targets are stored verbatim and resolved by the client, so they reach the client's /etc
+		resolved := "resolves on client"
+		if statErr != nil {
+			resolved = "unresolved " + errnoName(statErr)
+		}
+		stored := "verbatim"
+		if got != l.target {
+			stored = "rewritten"
+		}
+		report = append(report, semantics(l.name+" readlink", "verbatim", stored)+", "+resolved)
+		if got != l.target {
+			return opResult{Context: ctx, Details: strings.Join(report, "; ")}, fmt.Errorf("%s target rewritten: %q", l.name, got)
+		}
+	}
+	return opResult{Context: ctx, Details: strings.Join(report, "; ")}, nil
+}
+
+func opLchownLstatLink(dir string) (opResult, error) {
+	ctx := "Lchown a symlink (own and foreign uid), Lstat vs Stat"
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return opResult{Context: ctx}, err
+	}
+	target := filepath.Join(dir, "lchown-target.txt")
+	link := filepath.Join(dir, "lchown-link")
+	os.Remove(link)
+	defer os.Remove(target)
+	defer os.Remove(link)
+	if err := os.WriteFile(target, []byte("target"), 0644); err != nil {
+		return opResult{Context: ctx}, err
+	}
+	if err := os.Symlink("lchown-target.txt", link); err != nil {
+		return opResult{Context: ctx}, err
+	}
+
+	linfo, err := os.Lstat(link)
+	if err != nil {
+		return opResult{Context: ctx}, err
+	}
+	sinfo, err := os.Stat(link)
+	if err != nil {
+		return opResult{Context: ctx}, err
+	}
+	if linfo.Mode()&os.ModeSymlink == 0 || !sinfo.Mode().IsRegular() {
+		return opResult{Context: ctx}, fmt.Errorf("lstat mode=%s stat mode=%s, want symlink and regular", linfo.Mode(), sinfo.Mode())
+	}
+
+	uid, gid := os.Getuid(), os.Getgid()
+	ownErr := os.Lchown(link, uid, gid)
+
+	foreignExpected := "EPERM"
+	if os.Geteuid() == 0 {
+		foreignExpected = "ok"
+	}
+	const nobody = 65534
+	foreignErr := os.Lchown(link, nobody, nobody)
+
+	var linkOwner, targetOwner uint32
+	if st, err := lstatT(link); err == nil {
+		linkOwner = st.Uid
+	}
+	if st, err := statT(target); err == nil {
+		targetOwner = st.Uid
+	}
+
+	report := []string{
+		semantics("lchown own uid", "ok", outcome(ownErr)),
+		semantics(fmt.Sprintf("lchown uid %d", nobody), foreignExpected, outcome(foreignErr)),
+		fmt.Sprintf("link uid=%d target uid=%d", linkOwner, targetOwner),
+	}
+	details := strings.Join(report, "; ")
+	if ownErr != nil {
+		return opResult{Context: ctx, Details: details}, fmt.Errorf("lchown to own uid: %w", ownErr)
+	}
+	if foreignErr == nil && targetOwner == nobody {
+		return opResult{Context: ctx, Details: details}, fmt.Errorf("lchown followed the symlink and changed the target owner")
+	}
+	return opResult{Before: fmt.Sprintf("lstat=%s stat=%s", linfo.Mode(), sinfo.Mode()), Context: ctx, Details: details}, nil
+}
+
+func lstatT(path string) (*syscall.Stat_t, error) {
+	info, err := os.Lstat(path)
+	if err != nil {
+		return nil, err
+	}
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return nil, fmt.Errorf("lstat %s: no syscall.Stat_t", path)
+	}
+	return st, nil
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// syncOps returns ops covering durability calls. on NFS, fsync on a file written
+// with UNSTABLE writes sends a COMMIT; a server exporting with `sync` answers
+// writes as FILE_SYNC, so COMMIT counts in mountstats tell the two apart.
+func syncOps() []op {
+	return []op{
+		{"fsync_latency", opFsyncLatency},
+		{"fdatasync_latency", opFdatasyncLatency},
+		{"osync_write", opOSyncWrite},
+		{"odsync_write", opODSyncWrite},
+	}
+}
+
+const (
+	syncBlocks    = 20
+	syncBlockSize = 4096
+)
+
+// latencyStats summarises a set of per-call durations.
+type latencyStats struct {
+	Min, Avg, Max time.Duration
+}
+
+func (l latencyStats) String() string {
+	return fmt.Sprintf("min=%s avg=%s max=%s", l.Min, l.Avg, l.Max)
+}
+
+func summarizeLatency(samples []time.Duration) latencyStats {
+	if len(samples) == 0 {
+		return latencyStats{}
+	}
+	s := latencyStats{Min: samples[0], Max: samples[0]}
+	var total time.Duration
+	for _, d := range samples {
+		total += d
+		if d < s.Min {
+			s.Min = d
+		}
+		if d > s.Max {
+			s.Max = d
+		}
+	}
+	s.Avg = total / time.Duration(len(samples))
+	return s
+}
+
+// nfsOpCounts reads the per-op RPC counters for the NFS mount holding dir from
+// /proc/self/mountstats. ok is false when dir is not on an NFS mount.
+func nfsOpCounts(dir string) (counts map[string]int64, ok bool) {
+	mountPoint, fsType, _ := mountOptionsFor(dir)
+	if !strings.HasPrefix(fsType, "nfs") {
+		return nil, false
+	}
+	f, err := os.Open("/proc/self/mountstats")
+	if err != nil {
+		return nil, false
+	}
+	defer f.Close()
+
+	counts = map[string]int64{}
+	inMount, inOps := false, false
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "device ") {
+			fields := strings.Fields(line)
+			inMount = len(fields) >= 5 && fields[4] == mountPoint
+			inOps = false
+			continue
+		}
+		if !inMount {
+			continue
+		}
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "per-op statistics" {
+			inOps = true
+			continue
+		}
+		if !inOps {
+			continue
+		}
+		name, rest, found := strings.Cut(trimmed, ":")
+		if !found {
+			continue
+		}
+		fields := strings.Fields(rest)
+		if len(fields) == 0 {
+			continue
+		}
+		if n, err := strconv.ParseInt(fields[0], 10, 64); err == nil {
+			counts[name] = n
+		}
+	}
+	return counts, true
+}
+
+// commitDelta describes how many WRITE/COMMIT RPCs happened between two snapshots.
+// with stable set the client asked for FILE_SYNC writes itself (O_SYNC/O_DSYNC),
+// so the absence of COMMITs says nothing about the export.
+func commitDelta(before, after map[string]int64, ok, stable bool) string {
+	if !ok {
+		return "mountstats n/a (not nfs)"
+	}
+	commits := after["COMMIT"] - before["COMMIT"]
+	writes := after["WRITE"] - before["WRITE"]
+	export := "sync export (server replied FILE_SYNC, no COMMIT needed)"
+	switch {
+	case commits > 0:
+		export = "async export (unstable writes committed)"
+	case stable:
+		export = "stable writes requested by client, export mode not inferred"
+	}
+	return fmt.Sprintf("WRITE rpcs=%d COMMIT rpcs=%d -> %s", writes, commits, export)
+}
+
+// timedSyncWrites writes syncBlocks blocks to a fresh file opened with extraFlags,
+// calling syncFn (if any) after every write, and returns per-call latencies.
+func timedSyncWrites(path string, extraFlags int, syncFn func(*os.File) error) ([]time.Duration, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC|extraFlags, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("open: %w", err)
+	}
+	defer f.Close()
+
+	block := make([]byte, syncBlockSize)
+	var samples []time.Duration
+	for i := 0; i < syncBlocks; i++ {
+		for j := range block {
+			block[j] = byte(i + j)
+		}
+		start := time.Now()
+		if _, err := f.Write(block); err != nil {
+			return samples, fmt.Errorf("write block %d: %w", i, err)
+		}
+		if syncFn != nil {
+			if err := syncFn(f); err != nil {
+				return samples, fmt.Errorf("sync block %d: %w", i, err)
+			}
+		}
+		samples = append(samples, time.Since(start))
+	}
+	return samples, nil
+}
+
+// runSyncOp runs timedSyncWrites and a buffered baseline, reporting latency and COMMIT counts.
+func runSyncOp(dir, name, ctx string, extraFlags int, syncFn func(*os.File) error) (opResult, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return opResult{Context: ctx}, err
+	}
+	path := filepath.Join(dir, name+".bin")
+	baselinePath := filepath.Join(dir, name+"-buffered.bin")
+	defer os.Remove(path)
+	defer os.Remove(baselinePath)
+
+	baseline, err := timedSyncWrites(baselinePath, 0, nil)
+	if err != nil {
+		return opResult{Context: ctx}, fmt.Errorf("buffered baseline: %w", err)
+	}
+
+	statsBefore, ok := nfsOpCounts(dir)
+	samples, err := timedSyncWrites(path, extraFlags, syncFn)
+	if err != nil {
+		return opResult{Context: ctx}, err
+	}
+	statsAfter, _ := nfsOpCounts(dir)
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return opResult{Context: ctx}, err
+	}
+	want := int64(syncBlocks * syncBlockSize)
+	after := fmt.Sprintf("%s size=%d", filepath.Base(path), info.Size())
+	if info.Size() != want {
+		return opResult{After: after, Context: ctx}, fmt.Errorf("size mismatch: got %d, want %d", info.Size(), want)
+	}
+
+	return opResult{
+		Before:  fmt.Sprintf("buffered 4KB write %s", summarizeLatency(baseline)),
+		After:   after,
+		Context: ctx,
+		Details: fmt.Sprintf("%d x 4KB: %s; %s", syncBlocks, summarizeLatency(samples), commitDelta(statsBefore, statsAfter, ok, extraFlags != 0)),
+	}, nil
+}
+
+func opFsyncLatency(dir string) (opResult, error) {
+	return runSyncOp(dir, "fsync", "write 4KB + File.Sync per block", 0, (*os.File).Sync)
+}
+
+func opFdatasyncLatency(dir string) (opResult, error) {
+	return runSyncOp(dir, "fdatasync", "write 4KB + fdatasync per block", 0, func(f *os.File) error {
+		return syscall.Fdatasync(int(f.Fd()))
+	})
+}
+
+func opOSyncWrite(dir string) (opResult, error) {
+	return runSyncOp(dir, "osync", "O_SYNC open, 4KB writes", syscall.O_SYNC, nil)
+}
+
+func opODSyncWrite(dir string) (opResult, error) {
+	return runSyncOp(dir, "odsync", "O_DSYNC open, 4KB writes", syscall.O_DSYNC, nil)
+}
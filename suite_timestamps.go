@@ -0,0 +1,366 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// timestampOps returns ops covering atime/mtime/ctime semantics.
+// each op creates and removes its own files so they can run in any order.
+func timestampOps() []op {
+	return []op{
+		{"mtime_granularity", opMtimeGranularity},
+		{"ctime_on_metadata", opCtimeOnMetadata},
+		{"chtimes_roundtrip", opChtimesRoundtrip},
+		{"atime_behaviour", opAtimeBehaviour},
+		{"clock_skew", opClockSkew},
+	}
+}
+
+// fileTimes holds the three POSIX timestamps as reported by stat.
+type fileTimes struct {
+	Atime time.Time
+	Mtime time.Time
+	Ctime time.Time
+}
+
+func statTimes(path string) (fileTimes, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return fileTimes{}, err
+	}
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return fileTimes{}, fmt.Errorf("stat %s: no syscall.Stat_t", path)
+	}
+	return fileTimes{
+		Atime: time.Unix(st.Atim.Unix()),
+		Mtime: time.Unix(st.Mtim.Unix()),
+		Ctime: time.Unix(st.Ctim.Unix()),
+	}, nil
+}
+
+// timeGranularity guesses the timestamp resolution from the nanosecond field
+// of a set of samples: a field that is always a multiple of 1ms means ms precision, etc.
+func timeGranularity(samples []time.Time) time.Duration {
+	units := []time.Duration{time.Second, 100 * time.Millisecond, 10 * time.Millisecond, time.Millisecond, time.Microsecond, 100 * time.Nanosecond, 10 * time.Nanosecond}
+	for _, unit := range units {
+		aligned := true
+		for _, s := range samples {
+			if time.Duration(s.Nanosecond())%unit != 0 {
+				aligned = false
+				break
+			}
+		}
+		if aligned {
+			return unit
+		}
+	}
+	return time.Nanosecond
+}
+
+// mountOptionsFor returns the mount point, fs type and options of the filesystem holding path,
+// picking the longest matching mount point from /proc/self/mounts.
+func mountOptionsFor(path string) (mountPoint, fsType, options string) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		abs = path
+	}
+	f, err := os.Open("/proc/self/mounts")
+	if err != nil {
+		return "", "", ""
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 4 {
+			continue
+		}
+		mp := fields[1]
+		if abs != mp && !strings.HasPrefix(abs, strings.TrimSuffix(mp, "/")+"/") {
+			continue
+		}
+		if len(mp) >= len(mountPoint) {
+			mountPoint, fsType, options = mp, fields[2], fields[3]
+		}
+	}
+	return mountPoint, fsType, options
+}
+
+func opMtimeGranularity(dir string) (opResult, error) {
+	ctx := "sample mtime/ctime across rapid writes to infer precision"
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return opResult{Context: ctx}, err
+	}
+	path := filepath.Join(dir, "ts-granularity.txt")
+	defer os.Remove(path)
+
+	const samples = 20
+	var mtimes, ctimes []time.Time
+	minDelta := time.Duration(0)
+	for i := 0; i < samples; i++ {
+		if err := os.WriteFile(path, []byte(fmt.Sprintf("sample %d", i)), 0644); err != nil {
+			return opResult{Context: ctx}, fmt.Errorf("write sample %d: %w", i, err)
+		}
+		ts, err := statTimes(path)
+		if err != nil {
+			return opResult{Context: ctx}, fmt.Errorf("stat sample %d: %w", i, err)
+		}
+		if n := len(mtimes); n > 0 {
+			if d := ts.Mtime.Sub(mtimes[n-1]); d > 0 && (minDelta == 0 || d < minDelta) {
+				minDelta = d
+			}
+		}
+		mtimes = append(mtimes, ts.Mtime)
+		ctimes = append(ctimes, ts.Ctime)
+		time.Sleep(3 * time.Millisecond)
+	}
+
+	distinct := 1
+	for i := 1; i < len(mtimes); i++ {
+		if !mtimes[i].Equal(mtimes[i-1]) {
+			distinct++
+		}
+	}
+
+	before := fmt.Sprintf("first mtime=%s", mtimes[0].Format(time.RFC3339Nano))
+	after := fmt.Sprintf("last mtime=%s", mtimes[len(mtimes)-1].Format(time.RFC3339Nano))
+	return opResult{
+		Before:  before,
+		After:   after,
+		Context: ctx,
+		Details: fmt.Sprintf("mtime granularity~%s ctime granularity~%s, %d/%d distinct mtimes, smallest step=%s",
+			timeGranularity(mtimes), timeGranularity(ctimes), distinct, samples, minDelta),
+	}, nil
+}
+
+func opCtimeOnMetadata(dir string) (opResult, error) {
+	ctx := "verify ctime advances on chmod, link and rename"
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return opResult{Context: ctx}, err
+	}
+	path := filepath.Join(dir, "ts-ctime.txt")
+	renamed := filepath.Join(dir, "ts-ctime-renamed.txt")
+	link := filepath.Join(dir, "ts-ctime-link.txt")
+	defer os.Remove(path)
+	defer os.Remove(renamed)
+	defer os.Remove(link)
+
+	if err := os.WriteFile(path, []byte("ctime"), 0644); err != nil {
+		return opResult{Context: ctx}, err
+	}
+	start, err := statTimes(path)
+	if err != nil {
+		return opResult{Context: ctx}, err
+	}
+	before := fmt.Sprintf("ctime=%s", start.Ctime.Format(time.RFC3339Nano))
+
+	// each step waits long enough for coarse (1s) ctime to tick over
+	steps := []struct {
+		name string
+		path string
+		fn   func() error
+	}{
+		{"chmod", path, func() error { return os.Chmod(path, 0600) }},
+		{"link", path, func() error { return os.Link(path, link) }},
+		{"rename", renamed, func() error { return os.Rename(path, renamed) }},
+	}
+
+	prev := start.Ctime
+	var report, missed []string
+	for _, s := range steps {
+		time.Sleep(1100 * time.Millisecond)
+		if err := s.fn(); err != nil {
+			return opResult{Before: before, Context: ctx}, fmt.Errorf("%s: %w", s.name, err)
+		}
+		ts, err := statTimes(s.path)
+		if err != nil {
+			return opResult{Before: before, Context: ctx}, fmt.Errorf("stat after %s: %w", s.name, err)
+		}
+		advanced := ts.Ctime.After(prev)
+		report = append(report, fmt.Sprintf("%s=%v", s.name, advanced))
+		// POSIX requires chmod and link to update ctime; rename is implementation-defined
+		if !advanced && s.name != "rename" {
+			missed = append(missed, s.name)
+		}
+		prev = ts.Ctime
+	}
+
+	after := fmt.Sprintf("ctime=%s", prev.Format(time.RFC3339Nano))
+	if len(missed) > 0 {
+		return opResult{Before: before, After: after, Context: ctx}, fmt.Errorf("ctime did not advance on: %s", strings.Join(missed, ", "))
+	}
+	return opResult{Before: before, After: after, Context: ctx, Details: "ctime advanced: " + strings.Join(report, " ")}, nil
+}
+
+func opChtimesRoundtrip(dir string) (opResult, error) {
+	ctx := "os.Chtimes with sub-second, far-past and far-future values"
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return opResult{Context: ctx}, err
+	}
+	path := filepath.Join(dir, "ts-chtimes.txt")
+	defer os.Remove(path)
+	if err := os.WriteFile(path, []byte("chtimes"), 0644); err != nil {
+		return opResult{Context: ctx}, err
+	}
+
+	cases := []struct {
+		name string
+		t    time.Time
+	}{
+		{"subsecond", time.Date(2020, 6, 15, 12, 30, 45, 123456789, time.UTC)},
+		{"epoch", time.Unix(0, 0)},
+		{"pre_epoch", time.Date(1960, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{"y2038", time.Date(2038, 1, 19, 3, 14, 8, 0, time.UTC)},
+		{"far_future", time.Date(2200, 1, 1, 0, 0, 0, 0, time.UTC)},
+	}
+
+	var report []string
+	for _, c := range cases {
+		if err := os.Chtimes(path, c.t, c.t); err != nil {
+			report = append(report, fmt.Sprintf("%s=rejected(%v)", c.name, err))
+			continue
+		}
+		ts, err := statTimes(path)
+		if err != nil {
+			return opResult{Context: ctx}, fmt.Errorf("stat after %s: %w", c.name, err)
+		}
+		switch {
+		case ts.Mtime.Equal(c.t):
+			report = append(report, c.name+"=exact")
+		case ts.Mtime.Unix() == c.t.Unix():
+			report = append(report, fmt.Sprintf("%s=truncated(%s)", c.name, c.t.Sub(ts.Mtime)))
+		default:
+			report = append(report, fmt.Sprintf("%s=changed(%s)", c.name, ts.Mtime.UTC().Format(time.RFC3339Nano)))
+			// a whole-second value inside the 32-bit range must survive on any sane server
+			if c.name == "y2038" || c.name == "epoch" {
+				return opResult{Context: ctx, Details: strings.Join(report, " ")}, fmt.Errorf("%s mtime mismatch: set %s, got %s", c.name, c.t.Format(time.RFC3339), ts.Mtime.UTC().Format(time.RFC3339Nano))
+			}
+		}
+	}
+
+	return opResult{Context: ctx, Details: strings.Join(report, " ")}, nil
+}
+
+func opAtimeBehaviour(dir string) (opResult, error) {
+	ctx := "read file after backdating atime, report relatime/noatime behaviour"
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return opResult{Context: ctx}, err
+	}
+	path := filepath.Join(dir, "ts-atime.txt")
+	defer os.Remove(path)
+	if err := os.WriteFile(path, []byte("atime"), 0644); err != nil {
+		return opResult{Context: ctx}, err
+	}
+
+	// backdate atime by two days so relatime (which updates atime older than 24h) kicks in
+	old := time.Now().Add(-48 * time.Hour).Truncate(time.Second)
+	info, err := os.Stat(path)
+	if err != nil {
+		return opResult{Context: ctx}, err
+	}
+	if err := os.Chtimes(path, old, info.ModTime()); err != nil {
+		return opResult{Context: ctx}, fmt.Errorf("backdate atime: %w", err)
+	}
+	start, err := statTimes(path)
+	if err != nil {
+		return opResult{Context: ctx}, err
+	}
+	before := fmt.Sprintf("atime=%s", start.Atime.Format(time.RFC3339Nano))
+
+	if _, err := os.ReadFile(path); err != nil {
+		return opResult{Before: before, Context: ctx}, err
+	}
+	first, err := statTimes(path)
+	if err != nil {
+		return opResult{Before: before, Context: ctx}, err
+	}
+
+	time.Sleep(1100 * time.Millisecond)
+	if _, err := os.ReadFile(path); err != nil {
+		return opResult{Before: before, Context: ctx}, err
+	}
+	second, err := statTimes(path)
+	if err != nil {
+		return opResult{Before: before, Context: ctx}, err
+	}
+
+	after := fmt.Sprintf("atime=%s", second.Atime.Format(time.RFC3339Nano))
+
+	_, fsType, options := mountOptionsFor(dir)
+	mode := "strictatime"
+	for _, o := range strings.Split(options, ",") {
+		if o == "noatime" || o == "relatime" {
+			mode = o
+		}
+	}
+
+	observed := "no atime updates"
+	switch {
+	case first.Atime.After(start.Atime) && second.Atime.After(first.Atime):
+		observed = "atime updated on every read (strictatime)"
+	case first.Atime.After(start.Atime):
+		observed = "atime updated once past stale value (relatime)"
+	}
+
+	return opResult{
+		Before:  before,
+		After:   after,
+		Context: ctx,
+		Details: fmt.Sprintf("fs=%s mount=%s observed: %s", fsType, mode, observed),
+	}, nil
+}
+
+func opClockSkew(dir string) (opResult, error) {
+	ctx := "compare client clock with server-assigned mtime"
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return opResult{Context: ctx}, err
+	}
+	path := filepath.Join(dir, "ts-skew.txt")
+	defer os.Remove(path)
+
+	const samples = 5
+	var worst time.Duration
+	var sum time.Duration
+	for i := 0; i < samples; i++ {
+		clientBefore := time.Now()
+		if err := os.WriteFile(path, []byte(fmt.Sprintf("skew %d", i)), 0644); err != nil {
+			return opResult{Context: ctx}, err
+		}
+		clientAfter := time.Now()
+		ts, err := statTimes(path)
+		if err != nil {
+			return opResult{Context: ctx}, err
+		}
+
+		// skew is zero while the server stamp falls inside the client's write window
+		var skew time.Duration
+		switch {
+		case ts.Mtime.Before(clientBefore.Truncate(time.Second)):
+			skew = ts.Mtime.Sub(clientBefore)
+		case ts.Mtime.After(clientAfter):
+			skew = ts.Mtime.Sub(clientAfter)
+		}
+		sum += skew
+		abs := skew
+		if abs < 0 {
+			abs = -abs
+		}
+		if abs > worst {
+			worst = abs
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	return opResult{
+		Context: ctx,
+		Details: fmt.Sprintf("avg skew=%s worst=%s over %d writes (positive = server ahead)", sum/samples, worst, samples),
+	}, nil
+}
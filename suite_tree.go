@@ -0,0 +1,298 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// tree sizes default small enough to run in every matrix pass while still
+// paging past one 1024-entry readdir; raise TREE_WIDTH to 10k-100k to stress
+// a real export.
+var (
+	treeDepth   = getEnvInt("TREE_DEPTH", 32)
+	treeWidth   = getEnvInt("TREE_WIDTH", 2000)
+	treeWorkers = getEnvInt("TREE_WORKERS", 16)
+)
+
+// treeOps returns ops stressing very deep chains and very wide directories.
+func treeOps() []op {
+	return []op{
+		{"deep_tree", opDeepTree},
+		{"wide_dir_readdir", opWideDirReaddir},
+		{"readdir_during_mutation", opReaddirDuringMutation},
+	}
+}
+
+// parallelEach runs fn(i) for i in [0,n) on treeWorkers goroutines and returns the first error.
+func parallelEach(n int, fn func(i int) error) error {
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		firstErr error
+		next     = make(chan int)
+	)
+	for w := 0; w < treeWorkers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				if err := fn(i); err != nil {
+					mu.Lock()
+					if firstErr == nil {
+						firstErr = err
+					}
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+	for i := 0; i < n; i++ {
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+	return firstErr
+}
+
+func wideName(i int) string {
+	return fmt.Sprintf("entry-%07d", i)
+}
+
+// populateWide creates n empty files named wideName(0..n-1) in dir, in parallel.
+func populateWide(dir string, n int) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	return parallelEach(n, func(i int) error {
+		f, err := os.OpenFile(filepath.Join(dir, wideName(i)), os.O_CREATE|os.O_WRONLY, 0644)
+		if err != nil {
+			return err
+		}
+		return f.Close()
+	})
+}
+
+// removeWide deletes every entry of dir in parallel, then dir itself.
+func removeWide(dir string) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	err = parallelEach(len(entries), func(i int) error {
+		return os.RemoveAll(filepath.Join(dir, entries[i].Name()))
+	})
+	if err != nil {
+		return err
+	}
+	return os.Remove(dir)
+}
+
+// readdirPaged lists dir in batches of size n (one getdents / READDIR cookie chain),
+// invoking between after the first batch so callers can mutate the directory mid-listing.
+func readdirPaged(dir string, n int, between func()) (names []string, batches int, err error) {
+	d, err := os.Open(dir)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer d.Close()
+	for {
+		entries, err := d.ReadDir(n)
+		for _, e := range entries {
+			names = append(names, e.Name())
+		}
+		if len(entries) > 0 {
+			batches++
+			if batches == 1 && between != nil {
+				between()
+			}
+		}
+		if err == io.EOF {
+			return names, batches, nil
+		}
+		if err != nil {
+			return names, batches, err
+		}
+	}
+}
+
+func opDeepTree(dir string) (opResult, error) {
+	ctx := fmt.Sprintf("mkdir chain %d levels deep, stat leaf, remove", treeDepth)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return opResult{Context: ctx}, err
+	}
+	root := filepath.Join(dir, "tree-deep")
+	defer os.RemoveAll(root)
+
+	// create one level at a time like a recursive tool would, not a single MkdirAll
+	start := time.Now()
+	path := root
+	for i := 0; i < treeDepth; i++ {
+		path = filepath.Join(path, "d")
+		if err := os.MkdirAll(path, 0755); err != nil {
+			return opResult{Context: ctx}, fmt.Errorf("mkdir level %d (%d-byte path): %s", i, len(path), errnoName(err))
+		}
+	}
+	mkdirTime := time.Since(start)
+
+	leaf := filepath.Join(path, "leaf.txt")
+	if err := os.WriteFile(leaf, []byte("bottom"), 0644); err != nil {
+		return opResult{Context: ctx}, fmt.Errorf("create leaf: %w", err)
+	}
+	start = time.Now()
+	if _, err := os.Stat(leaf); err != nil {
+		return opResult{Context: ctx}, fmt.Errorf("stat leaf: %w", err)
+	}
+	statTime := time.Since(start)
+	before := fmt.Sprintf("depth=%d path=%d bytes", treeDepth, len(leaf))
+
+	start = time.Now()
+	if err := os.RemoveAll(root); err != nil {
+		return opResult{Before: before, Context: ctx}, fmt.Errorf("remove deep tree: %w", err)
+	}
+	rmTime := time.Since(start)
+
+	return opResult{Before: before, After: "tree-deep/ removed", Context: ctx, Details: fmt.Sprintf("mkdir=%s (%s/level) leaf stat=%s remove=%s", mkdirTime, mkdirTime/time.Duration(treeDepth), statTime, rmTime)}, nil
+}
+
+func opWideDirReaddir(dir string) (opResult, error) {
+	ctx := fmt.Sprintf("%d entries in one dir: os.ReadDir and 1024-entry pages", treeWidth)
+	wide := filepath.Join(dir, "tree-wide")
+	defer os.RemoveAll(wide)
+
+	start := time.Now()
+	if err := populateWide(wide, treeWidth); err != nil {
+		return opResult{Context: ctx}, fmt.Errorf("populate: %w", err)
+	}
+	createTime := time.Since(start)
+	before := fmt.Sprintf("created %d entries in %s with %d workers", treeWidth, createTime, treeWorkers)
+
+	statsBefore, ok := nfsOpCounts(dir)
+	start = time.Now()
+	entries, err := os.ReadDir(wide)
+	if err != nil {
+		return opResult{Before: before, Context: ctx}, fmt.Errorf("readdir: %w", err)
+	}
+	fullTime := time.Since(start)
+
+	start = time.Now()
+	paged, batches, err := readdirPaged(wide, 1024, nil)
+	if err != nil {
+		return opResult{Before: before, Context: ctx}, fmt.Errorf("paged readdir: %w", err)
+	}
+	pagedTime := time.Since(start)
+	statsAfter, _ := nfsOpCounts(dir)
+
+	seen := map[string]int{}
+	for _, n := range paged {
+		seen[n]++
+	}
+	var dupes, missing int
+	for _, c := range seen {
+		if c > 1 {
+			dupes++
+		}
+	}
+	for i := 0; i < treeWidth; i++ {
+		if seen[wideName(i)] == 0 {
+			missing++
+		}
+	}
+
+	start = time.Now()
+	if err := removeWide(wide); err != nil {
+		return opResult{Before: before, Context: ctx}, fmt.Errorf("parallel cleanup: %w", err)
+	}
+	rmTime := time.Since(start)
+
+	after := fmt.Sprintf("readdir=%d entries in %s, paged=%d entries in %d pages (%s)", len(entries), fullTime, len(paged), batches, pagedTime)
+	details := fmt.Sprintf("dupes=%d missing=%d cleanup=%s; %s", dupes, missing, rmTime, nfsOpDelta(statsBefore, statsAfter, ok, "READDIR", "READDIRPLUS"))
+	if len(entries) != treeWidth || dupes > 0 || missing > 0 {
+		return opResult{Before: before, After: after, Context: ctx, Details: details}, fmt.Errorf("listing inconsistent: readdir=%d want %d, dupes=%d missing=%d", len(entries), treeWidth, dupes, missing)
+	}
+	return opResult{Before: before, After: after, Context: ctx, Details: details}, nil
+}
+
+func opReaddirDuringMutation(dir string) (opResult, error) {
+	ctx := "add and remove entries between readdir pages; stable entries must appear exactly once"
+	wide := filepath.Join(dir, "tree-mutate")
+	defer os.RemoveAll(wide)
+
+	if err := populateWide(wide, treeWidth); err != nil {
+		return opResult{Context: ctx}, fmt.Errorf("populate: %w", err)
+	}
+
+	// remove the last tenth and add as many new names while the listing is in flight
+	churn := treeWidth / 10
+	removed := map[string]bool{}
+	added := map[string]bool{}
+	var mutateErr error
+	mutate := func() {
+		for i := treeWidth - churn; i < treeWidth; i++ {
+			name := wideName(i)
+			if err := os.Remove(filepath.Join(wide, name)); err != nil && mutateErr == nil {
+				mutateErr = err
+			}
+			removed[name] = true
+		}
+		for i := 0; i < churn; i++ {
+			name := fmt.Sprintf("added-%07d", i)
+			if err := os.WriteFile(filepath.Join(wide, name), nil, 0644); err != nil && mutateErr == nil {
+				mutateErr = err
+			}
+			added[name] = true
+		}
+	}
+
+	names, batches, err := readdirPaged(wide, 256, mutate)
+	if err != nil {
+		return opResult{Context: ctx}, fmt.Errorf("paged readdir: %w", err)
+	}
+	if mutateErr != nil {
+		return opResult{Context: ctx}, fmt.Errorf("mutate during readdir: %w", mutateErr)
+	}
+	before := fmt.Sprintf("%d entries, churn=%d removed + %d added after first page", treeWidth, churn, churn)
+
+	seen := map[string]int{}
+	for _, n := range names {
+		seen[n]++
+	}
+	var dupes, missingStable, seenRemoved, seenAdded int
+	var examples []string
+	for n, c := range seen {
+		if c > 1 {
+			dupes++
+			if len(examples) < 5 {
+				examples = append(examples, n)
+			}
+		}
+		if removed[n] {
+			seenRemoved++
+		}
+		if added[n] {
+			seenAdded++
+		}
+	}
+	for i := 0; i < treeWidth-churn; i++ {
+		if seen[wideName(i)] == 0 {
+			missingStable++
+		}
+	}
+
+	removeWide(wide)
+
+	after := fmt.Sprintf("%d names in %d pages", len(names), batches)
+	// POSIX leaves it unspecified whether entries added/removed mid-listing show up
+	details := fmt.Sprintf("dupes=%d missing_stable=%d removed_still_listed=%d added_listed=%d", dupes, missingStable, seenRemoved, seenAdded)
+	if dupes > 0 || missingStable > 0 {
+		if len(examples) > 0 {
+			details += " e.g. " + strings.Join(examples, ",")
+		}
+		return opResult{Before: before, After: after, Context: ctx, Details: details}, fmt.Errorf("readdir cookies unstable under mutation: %d duplicates, %d stable entries missing", dupes, missingStable)
+	}
+	return opResult{Before: before, After: after, Context: ctx, Details: details}, nil
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// unlinkOps returns ops covering delete-while-open semantics.
+// on NFS the client keeps an unlinked-but-open file alive by renaming it to .nfsXXXX
+// (silly rename) and removing it on last close; local filesystems just drop the name.
+func unlinkOps() []op {
+	return []op{
+		{"unlink_while_open", opUnlinkWhileOpen},
+		{"rmdir_with_open_file", opRmdirWithOpenFile},
+	}
+}
+
+// sillyRenames lists .nfs* entries in dir.
+func sillyRenames(dir string) []string {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil
+	}
+	var names []string
+	for _, e := range entries {
+		if strings.HasPrefix(e.Name(), ".nfs") {
+			names = append(names, e.Name())
+		}
+	}
+	return names
+}
+
+// waitNoSillyRenames polls dir until no .nfs* entries remain or the timeout expires.
+func waitNoSillyRenames(dir string, timeout time.Duration) []string {
+	deadline := time.Now().Add(timeout)
+	for {
+		left := sillyRenames(dir)
+		if len(left) == 0 || time.Now().After(deadline) {
+			return left
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+func opUnlinkWhileOpen(dir string) (opResult, error) {
+	ctx := "unlink open file, keep using fd, check .nfs* sillyrename lifecycle"
+	subdir := filepath.Join(dir, "unlink-open")
+	if err := os.MkdirAll(subdir, 0755); err != nil {
+		return opResult{Context: ctx}, err
+	}
+	defer os.RemoveAll(subdir)
+
+	path := filepath.Join(subdir, "victim.txt")
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR|os.O_TRUNC, 0644)
+	if err != nil {
+		return opResult{Context: ctx}, err
+	}
+	defer f.Close()
+
+	first := []byte("written before unlink\n")
+	if _, err := f.Write(first); err != nil {
+		return opResult{Context: ctx}, err
+	}
+	before := fmt.Sprintf("victim.txt open, %d bytes, sillyrenames=%d", len(first), len(sillyRenames(subdir)))
+
+	if err := os.Remove(path); err != nil {
+		return opResult{Before: before, Context: ctx}, fmt.Errorf("unlink open file: %w", err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		return opResult{Before: before, Context: ctx}, fmt.Errorf("victim.txt still visible after unlink: %v", err)
+	}
+	whileOpen := sillyRenames(subdir)
+
+	second := []byte("written after unlink\n")
+	if _, err := f.Write(second); err != nil {
+		return opResult{Before: before, Context: ctx}, fmt.Errorf("write after unlink: %w", err)
+	}
+	buf := make([]byte, len(first)+len(second))
+	if _, err := f.ReadAt(buf, 0); err != nil {
+		return opResult{Before: before, Context: ctx}, fmt.Errorf("read after unlink: %w", err)
+	}
+	if !bytes.Equal(buf, append(append([]byte{}, first...), second...)) {
+		return opResult{Before: before, Context: ctx}, fmt.Errorf("content mismatch after unlink: got %q", string(buf))
+	}
+
+	if err := f.Close(); err != nil {
+		return opResult{Before: before, Context: ctx}, fmt.Errorf("close after unlink: %w", err)
+	}
+	leftover := waitNoSillyRenames(subdir, 5*time.Second)
+
+	after := fmt.Sprintf("sillyrenames while open=%v, after close=%v", whileOpen, leftover)
+	if len(leftover) > 0 {
+		return opResult{Before: before, After: after, Context: ctx}, fmt.Errorf("sillyrename entries survived close: %s", strings.Join(leftover, ", "))
+	}
+
+	details := "fd usable after unlink, no sillyrename needed (local semantics)"
+	if len(whileOpen) > 0 {
+		details = fmt.Sprintf("fd usable after unlink, sillyrenamed to %s, removed on close", whileOpen[0])
+	}
+	return opResult{Before: before, After: after, Context: ctx, Details: details}, nil
+}
+
+func opRmdirWithOpenFile(dir string) (opResult, error) {
+	ctx := "rmdir a dir whose only file is unlinked but still open"
+	subdir := filepath.Join(dir, "rmdir-open")
+	if err := os.MkdirAll(subdir, 0755); err != nil {
+		return opResult{Context: ctx}, err
+	}
+	defer os.RemoveAll(subdir)
+
+	path := filepath.Join(subdir, "held.txt")
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR|os.O_TRUNC, 0644)
+	if err != nil {
+		return opResult{Context: ctx}, err
+	}
+	defer f.Close()
+	if _, err := f.WriteString("held open"); err != nil {
+		return opResult{Context: ctx}, err
+	}
+
+	if err := os.Remove(path); err != nil {
+		return opResult{Context: ctx}, fmt.Errorf("unlink open file: %w", err)
+	}
+	before := fmt.Sprintf("held.txt unlinked, fd open, sillyrenames=%v", sillyRenames(subdir))
+
+	rmErr := syscall.Rmdir(subdir)
+	f.Close()
+
+	observed := "rmdir succeeded"
+	if rmErr != nil {
+		observed = fmt.Sprintf("rmdir failed with %s", errnoName(rmErr))
+		// the sillyrenamed file is gone after close, so rmdir should now work
+		waitNoSillyRenames(subdir, 5*time.Second)
+		if err := syscall.Rmdir(subdir); err != nil {
+			return opResult{Before: before, Context: ctx, Details: observed}, fmt.Errorf("rmdir after close: %w", err)
+		}
+		observed += ", succeeded after close"
+	}
+
+	_, statErr := os.Stat(subdir)
+	after := fmt.Sprintf("rmdir-open/ exists=%v", statErr == nil)
+	return opResult{Before: before, After: after, Context: ctx, Details: observed}, nil
+}
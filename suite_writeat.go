@@ -0,0 +1,211 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+)
+
+// writeAtOps returns ops where several writers share one file via WriteAt.
+// this catches page-cache write-back reordering and lost updates between
+// writers, which single-writer ops never exercise.
+func writeAtOps() []op {
+	return []op{
+		{"writeat_disjoint_overlap", opWriteAtDisjointOverlap},
+	}
+}
+
+const (
+	writeAtBlockSize  = 4096
+	writeAtGoroutines = 4
+	writeAtProcesses  = 2
+	writeAtBlocksEach = 16
+	// the overlap phase has every writer rewrite this many leading blocks in one WriteAt
+	writeAtOverlapBlocks = 8
+)
+
+// writeAtBlock builds a checksummed block stamped with its writer, position and phase.
+// the final 4 bytes are a CRC32 of the rest.
+func writeAtBlock(writer, block, phase int) []byte {
+	buf := make([]byte, writeAtBlockSize)
+	seed := uint32(writer*7919 + block*31 + phase)
+	for i := 0; i < len(buf)-4; i += 4 {
+		seed = seed*1664525 + 1013904223
+		binary.LittleEndian.PutUint32(buf[i:], seed)
+	}
+	copy(buf, fmt.Sprintf("w%04d b%06d p%d\n", writer, block, phase))
+	binary.LittleEndian.PutUint32(buf[len(buf)-4:], crc32.ChecksumIEEE(buf[:len(buf)-4]))
+	return buf
+}
+
+// parseWriteAtBlock validates a block read from position block and returns its writer and phase.
+func parseWriteAtBlock(buf []byte, block int) (writer, phase int, ok bool) {
+	if len(buf) != writeAtBlockSize {
+		return 0, 0, false
+	}
+	if crc32.ChecksumIEEE(buf[:len(buf)-4]) != binary.LittleEndian.Uint32(buf[len(buf)-4:]) {
+		return 0, 0, false
+	}
+	var got int
+	if _, err := fmt.Sscanf(string(buf[:18]), "w%04d b%06d p%d\n", &writer, &got, &phase); err != nil || got != block {
+		return 0, 0, false
+	}
+	return writer, phase, true
+}
+
+// writeAtPhase writes writer's share of blocks: in phase 0 every nWriters-th block
+// starting at writer, in phase 1 the shared leading region in one call.
+func writeAtPhase(path string, writer, nWriters, phase int) error {
+	f, err := os.OpenFile(path, os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if phase == 1 {
+		region := make([]byte, 0, writeAtOverlapBlocks*writeAtBlockSize)
+		for b := 0; b < writeAtOverlapBlocks; b++ {
+			region = append(region, writeAtBlock(writer, b, phase)...)
+		}
+		if _, err := f.WriteAt(region, 0); err != nil {
+			return fmt.Errorf("writer %d overlap write: %w", writer, err)
+		}
+		return f.Sync()
+	}
+
+	for i := 0; i < writeAtBlocksEach; i++ {
+		b := i*nWriters + writer
+		if _, err := f.WriteAt(writeAtBlock(writer, b, phase), int64(b)*writeAtBlockSize); err != nil {
+			return fmt.Errorf("writer %d block %d: %w", writer, b, err)
+		}
+	}
+	return f.Sync()
+}
+
+// childWriteAt is the child-process side of writeat_disjoint_overlap: args are path, writer, nWriters, phase.
+func childWriteAt(args []string) error {
+	if len(args) != 4 {
+		return fmt.Errorf("usage: <path> <writer> <writers> <phase>")
+	}
+	var n [3]int
+	for i, a := range args[1:] {
+		v, err := strconv.Atoi(a)
+		if err != nil {
+			return err
+		}
+		n[i] = v
+	}
+	return writeAtPhase(args[0], n[0], n[1], n[2])
+}
+
+// runWriteAtPhase runs one phase with goroutine writers 0..g-1 and process writers g..n-1 concurrently.
+func runWriteAtPhase(path string, phase int) error {
+	nWriters := writeAtGoroutines + writeAtProcesses
+	var argSets [][]string
+	for w := writeAtGoroutines; w < nWriters; w++ {
+		argSets = append(argSets, []string{path, strconv.Itoa(w), strconv.Itoa(nWriters), strconv.Itoa(phase)})
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, writeAtGoroutines+1)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		if err := runChildren("writeat", argSets); err != nil {
+			errs <- err
+		}
+	}()
+	for w := 0; w < writeAtGoroutines; w++ {
+		wg.Add(1)
+		go func(writer int) {
+			defer wg.Done()
+			if err := writeAtPhase(path, writer, nWriters, phase); err != nil {
+				errs <- err
+			}
+		}(w)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		return err
+	}
+	return nil
+}
+
+func opWriteAtDisjointOverlap(dir string) (opResult, error) {
+	nWriters := writeAtGoroutines + writeAtProcesses
+	ctx := fmt.Sprintf("%d goroutines + %d processes WriteAt checksummed 4KB blocks into one file", writeAtGoroutines, writeAtProcesses)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return opResult{Context: ctx}, err
+	}
+	path := filepath.Join(dir, "writeat-shared.bin")
+	defer os.Remove(path)
+
+	totalBlocks := nWriters * writeAtBlocksEach
+	if err := os.WriteFile(path, nil, 0644); err != nil {
+		return opResult{Context: ctx}, err
+	}
+	before := fmt.Sprintf("%d writers, %d blocks, %d-block overlap region", nWriters, totalBlocks, writeAtOverlapBlocks)
+
+	if err := runWriteAtPhase(path, 0); err != nil {
+		return opResult{Before: before, Context: ctx}, fmt.Errorf("disjoint phase: %w", err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return opResult{Before: before, Context: ctx}, err
+	}
+	if len(data) != totalBlocks*writeAtBlockSize {
+		return opResult{Before: before, Context: ctx}, fmt.Errorf("size after disjoint phase: got %d, want %d", len(data), totalBlocks*writeAtBlockSize)
+	}
+	var corrupt, misplaced []int
+	for b := 0; b < totalBlocks; b++ {
+		writer, _, ok := parseWriteAtBlock(data[b*writeAtBlockSize:(b+1)*writeAtBlockSize], b)
+		switch {
+		case !ok:
+			corrupt = append(corrupt, b)
+		case writer != b%nWriters:
+			misplaced = append(misplaced, b)
+		}
+	}
+	if len(corrupt) > 0 || len(misplaced) > 0 {
+		return opResult{Before: before, Context: ctx}, fmt.Errorf("disjoint phase: %d corrupt blocks %v, %d blocks from wrong writer %v", len(corrupt), corrupt, len(misplaced), misplaced)
+	}
+
+	if err := runWriteAtPhase(path, 1); err != nil {
+		return opResult{Before: before, Context: ctx}, fmt.Errorf("overlap phase: %w", err)
+	}
+	data, err = os.ReadFile(path)
+	if err != nil {
+		return opResult{Before: before, Context: ctx}, err
+	}
+	winners := map[int]int{}
+	for b := 0; b < totalBlocks; b++ {
+		writer, phase, ok := parseWriteAtBlock(data[b*writeAtBlockSize:(b+1)*writeAtBlockSize], b)
+		switch {
+		case !ok:
+			corrupt = append(corrupt, b)
+		case b < writeAtOverlapBlocks && phase != 1:
+			// an overlap block that still holds phase-0 data lost every overlapping update
+			misplaced = append(misplaced, b)
+		case b < writeAtOverlapBlocks:
+			winners[writer]++
+		case writer != b%nWriters || phase != 0:
+			misplaced = append(misplaced, b)
+		}
+	}
+
+	after := fmt.Sprintf("overlap region winners (writer:blocks)=%v", winners)
+	if len(corrupt) > 0 || len(misplaced) > 0 {
+		return opResult{Before: before, After: after, Context: ctx}, fmt.Errorf("overlap phase: %d corrupt blocks %v, %d lost/misplaced blocks %v", len(corrupt), corrupt, len(misplaced), misplaced)
+	}
+
+	mixed := "single writer won the whole region"
+	if len(winners) > 1 {
+		mixed = fmt.Sprintf("region interleaved across %d writers (writes not atomic across blocks)", len(winners))
+	}
+	return opResult{Before: before, After: after, Context: ctx, Details: fmt.Sprintf("%d blocks checksummed OK; %s", totalBlocks, mixed)}, nil
+}
@@ -29,12 +29,16 @@ echo ""
 
 # 1. login
 echo "--- step 1: login as alice ---"
-status=$(curl -s -o /dev/null -w '%{http_code}' \
+LOGIN_FILE=$(mktemp)
+status=$(curl -s -o "$LOGIN_FILE" -w '%{http_code}' \
   -c "$COOKIE_JAR" \
   -H 'Content-Type: application/json' \
   -d '{"username":"alice","password":"password123"}' \
   "$BASE_URL/api/v1/login")
 check "POST /api/v1/login" 200 "$status"
+# state-changing requests must echo the session's CSRF token
+csrf_token=$(grep -o '"csrf_token"[[:space:]]*:[[:space:]]*"[^"]*"' "$LOGIN_FILE" | head -1 | sed 's/.*: *"//;s/".*//' || true)
+rm -f "$LOGIN_FILE"
 
 # 2. loop 20x, collect served_by instances
 echo ""
@@ -92,6 +96,7 @@ echo "--- step 4: logout ---"
 status=$(curl -s -o /dev/null -w '%{http_code}' \
   -b "$COOKIE_JAR" -c "$COOKIE_JAR" \
   -X POST \
+  -H "X-CSRF-Token: $csrf_token" \
   "$BASE_URL/api/v1/logout")
 check "POST /api/v1/logout" 200 "$status"
 
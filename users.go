@@ -0,0 +1,515 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+var (
+	usersPath  = getEnv("USERS_PATH", "/data/users")
+	bcryptCost = getEnvInt("BCRYPT_COST", bcrypt.DefaultCost)
+)
+
+var (
+	ErrUserExists   = errors.New("user already exists")
+	ErrUserNotFound = errors.New("user not found")
+	ErrBadPassword  = errors.New("invalid credentials")
+	ErrInvalidUser  = errors.New("invalid user")
+)
+
+const (
+	roleAdmin = "admin"
+	roleUser  = "user"
+
+	minPasswordLen = 8
+	usersLockFile  = ".users.lock"
+)
+
+var usernamePattern = regexp.MustCompile(`^[a-z0-9_-]{1,32}$`)
+
+// demo accounts seeded into an empty user store, so a fresh mount still logs in
+var seedUsers = []struct {
+	username, password, role string
+}{
+	{"alice", "password123", roleAdmin},
+	{"bob", "password456", roleUser},
+}
+
+type User struct {
+	Username     string `json:"username"`
+	PasswordHash string `json:"password_hash"`
+	Role         string `json:"role"`
+	CreatedAt    string `json:"created_at"`
+	UpdatedAt    string `json:"updated_at"`
+	UpdatedBy    string `json:"updated_by"`
+	// bumped on every write; concurrent updates from other instances show up as gaps
+	Version int `json:"version"`
+}
+
+// public strips the password hash for API responses.
+func (u *User) public() map[string]interface{} {
+	return map[string]interface{}{
+		"username":   u.Username,
+		"role":       u.Role,
+		"created_at": u.CreatedAt,
+		"updated_at": u.UpdatedAt,
+		"updated_by": u.UpdatedBy,
+		"version":    u.Version,
+	}
+}
+
+// UserStore keeps one JSON file per user in dir. every read-modify-write runs
+// under a lock on a shared lock file, so registrations and password
+// changes from different instances serialise instead of losing updates; files
+// are replaced with write-then-rename so lock-free readers never see half a user.
+type UserStore struct {
+	dir  string
+	cost int
+	// compared against for unknown users, hashed at the same cost as real ones
+	dummy []byte
+}
+
+func NewUserStore(dir string, cost int) (*UserStore, error) {
+	os.MkdirAll(dir, 0755)
+	// gvisor gofer ignores mode on mkdir over NFS, force correct perms
+	os.Chmod(dir, 0755)
+	dummy, err := bcrypt.GenerateFromPassword([]byte("not-a-real-password"), cost)
+	if err != nil {
+		return nil, fmt.Errorf("hash dummy password: %w", err)
+	}
+	s := &UserStore{dir: dir, cost: cost, dummy: dummy}
+	if err := s.seed(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// seed creates the demo accounts when no users exist yet. every instance may
+// race to do this at startup; the lock makes exactly one of them win.
+func (s *UserStore) seed() error {
+	return s.locked(func() error {
+		users, err := s.list()
+		if err != nil || len(users) > 0 {
+			return err
+		}
+		for _, u := range seedUsers {
+			if _, err := s.create(u.username, u.password, u.role, "seed"); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *UserStore) Create(username, password, role, by string) (*User, error) {
+	var u *User
+	err := s.locked(func() error {
+		var err error
+		u, err = s.create(username, password, role, by)
+		return err
+	})
+	return u, err
+}
+
+func (s *UserStore) create(username, password, role, by string) (*User, error) {
+	if !usernamePattern.MatchString(username) {
+		return nil, fmt.Errorf("%w: username must match %s", ErrInvalidUser, usernamePattern)
+	}
+	if role != roleAdmin && role != roleUser {
+		return nil, fmt.Errorf("%w: role must be %q or %q", ErrInvalidUser, roleAdmin, roleUser)
+	}
+	if _, err := s.Get(username); err == nil {
+		return nil, ErrUserExists
+	}
+	hash, err := s.hash(password)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now().UTC().Format(time.RFC3339)
+	u := &User{Username: username, PasswordHash: hash, Role: role, CreatedAt: now}
+	return u, s.write(u, by)
+}
+
+func (s *UserStore) Get(username string) (*User, error) {
+	if !usernamePattern.MatchString(username) {
+		return nil, ErrUserNotFound
+	}
+	data, err := os.ReadFile(filepath.Join(s.dir, username+".json"))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrUserNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	var u User
+	if err := json.Unmarshal(data, &u); err != nil {
+		return nil, fmt.Errorf("unmarshal user: %w", err)
+	}
+	return &u, nil
+}
+
+// Authenticate checks a password. unknown users still pay for a bcrypt
+// comparison so response time doesn't reveal which usernames exist.
+func (s *UserStore) Authenticate(username, password string) (*User, error) {
+	u, err := s.Get(username)
+	if err != nil {
+		bcrypt.CompareHashAndPassword(s.dummy, []byte(password))
+		return nil, ErrBadPassword
+	}
+	if bcrypt.CompareHashAndPassword([]byte(u.PasswordHash), []byte(password)) != nil {
+		return nil, ErrBadPassword
+	}
+	return u, nil
+}
+
+// Update applies fn to the stored user and writes it back under the lock.
+func (s *UserStore) Update(username, by string, fn func(u *User) error) (*User, error) {
+	var u *User
+	err := s.locked(func() error {
+		var err error
+		if u, err = s.Get(username); err != nil {
+			return err
+		}
+		if err := fn(u); err != nil {
+			return err
+		}
+		return s.write(u, by)
+	})
+	return u, err
+}
+
+func (s *UserStore) SetPassword(username, password, by string) (*User, error) {
+	return s.Update(username, by, func(u *User) error {
+		hash, err := s.hash(password)
+		if err != nil {
+			return err
+		}
+		u.PasswordHash = hash
+		return nil
+	})
+}
+
+func (s *UserStore) Delete(username string) error {
+	return s.locked(func() error {
+		if !usernamePattern.MatchString(username) {
+			return ErrUserNotFound
+		}
+		err := os.Remove(filepath.Join(s.dir, username+".json"))
+		if errors.Is(err, os.ErrNotExist) {
+			return ErrUserNotFound
+		}
+		return err
+	})
+}
+
+func (s *UserStore) List() ([]User, error) {
+	return s.list()
+}
+
+func (s *UserStore) list() ([]User, error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+	var users []User
+	for _, e := range entries {
+		if !strings.HasSuffix(e.Name(), ".json") || strings.HasPrefix(e.Name(), ".") {
+			continue
+		}
+		u, err := s.Get(strings.TrimSuffix(e.Name(), ".json"))
+		if err != nil {
+			continue
+		}
+		users = append(users, *u)
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].Username < users[j].Username })
+	return users, nil
+}
+
+func (s *UserStore) hash(password string) (string, error) {
+	if len(password) < minPasswordLen {
+		return "", fmt.Errorf("%w: password must be at least %d characters", ErrInvalidUser, minPasswordLen)
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), s.cost)
+	if err != nil {
+		return "", fmt.Errorf("hash password: %w", err)
+	}
+	return string(hash), nil
+}
+
+// write stamps and persists u. callers hold the lock.
+func (s *UserStore) write(u *User, by string) error {
+	u.Version++
+	u.UpdatedAt = time.Now().UTC().Format(time.RFC3339Nano)
+	u.UpdatedBy = by
+	data, err := json.MarshalIndent(u, "", "  ")
+	if err != nil {
+		return fmt.Errorf("marshal user: %w", err)
+	}
+	// user files hold password hashes, keep them away from other local accounts.
+	// writes are rare, so they are always fsynced
+	if err := writeFileAtomic(filepath.Join(s.dir, u.Username+".json"), data, 0600, true); err != nil {
+		return fmt.Errorf("write user file: %w", err)
+	}
+	return nil
+}
+
+// locked runs fn while holding the store-wide lock, blocking until other instances release it.
+func (s *UserStore) locked(fn func() error) error {
+	unlock, _, err := lockPath(filepath.Join(s.dir, usersLockFile), true)
+	if err != nil {
+		return fmt.Errorf("lock users: %w", err)
+	}
+	defer unlock()
+	return fn()
+}
+
+var users *UserStore
+
+// currentUser resolves the request's session (see requireSession) to its user,
+// re-reading the user file so role changes and deletions made on other instances
+// apply at once. it writes the 403 itself and returns nil when there is no valid session.
+func currentUser(w http.ResponseWriter, r *http.Request) *User {
+	sess := requireSession(w, r)
+	if sess == nil {
+		return nil
+	}
+	u, err := users.Get(sess.Username)
+	if err != nil {
+		w.WriteHeader(http.StatusForbidden)
+		writeJSON(w, map[string]string{"error": "user no longer exists"})
+		return nil
+	}
+	return u
+}
+
+// deleteUserSessions removes a deleted user's sessions, which would otherwise
+// stay valid on every endpoint that only checks the session.
+func deleteUserSessions(username string) (int, error) {
+	list, err := sessions.ListByUser(username)
+	if err != nil {
+		return 0, err
+	}
+	n := 0
+	for _, sess := range list {
+		if err := sessions.Delete(sess.SessionID); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return n, err
+		}
+		n++
+	}
+	return n, nil
+}
+
+// writeUserError maps store errors onto status codes.
+func writeUserError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, ErrUserExists):
+		w.WriteHeader(http.StatusConflict)
+	case errors.Is(err, ErrUserNotFound):
+		w.WriteHeader(http.StatusNotFound)
+	case errors.Is(err, ErrBadPassword):
+		w.WriteHeader(http.StatusUnauthorized)
+	case errors.Is(err, ErrInvalidUser):
+		w.WriteHeader(http.StatusBadRequest)
+	default:
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+	writeJSON(w, map[string]string{"error": err.Error()})
+}
+
+func handleRegister(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "POST only", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		Username string `json:"username"`
+		Password string `json:"password"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		writeJSON(w, map[string]string{"error": "invalid json"})
+		return
+	}
+
+	u, err := users.Create(req.Username, req.Password, roleUser, hostname)
+	if err != nil {
+		writeUserError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	resp := u.public()
+	resp["served_by"] = hostname
+	writeJSON(w, resp)
+}
+
+func handleChangePassword(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "POST only", http.StatusMethodNotAllowed)
+		return
+	}
+	u := currentUser(w, r)
+	if u == nil {
+		return
+	}
+
+	var req struct {
+		CurrentPassword string `json:"current_password"`
+		NewPassword     string `json:"new_password"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		writeJSON(w, map[string]string{"error": "invalid json"})
+		return
+	}
+	if _, err := users.Authenticate(u.Username, req.CurrentPassword); err != nil {
+		writeUserError(w, err)
+		return
+	}
+
+	u, err := users.SetPassword(u.Username, req.NewPassword, hostname)
+	if err != nil {
+		writeUserError(w, err)
+		return
+	}
+
+	resp := u.public()
+	resp["served_by"] = hostname
+	writeJSON(w, resp)
+}
+
+// handleAdminUsers serves the admin user-management API:
+//
+//	GET  /api/v1/admin/users                   list users
+//	POST /api/v1/admin/users                   create {username, password, role}
+//	POST /api/v1/admin/users/update            update {username, password?, role?}
+//	POST /api/v1/admin/users/delete/<username> delete
+func handleAdminUsers(w http.ResponseWriter, r *http.Request) {
+	admin := currentUser(w, r)
+	if admin == nil {
+		return
+	}
+	if admin.Role != roleAdmin {
+		w.WriteHeader(http.StatusForbidden)
+		writeJSON(w, map[string]string{"error": "admin only"})
+		return
+	}
+
+	path := strings.TrimPrefix(r.URL.Path, "/api/v1/admin/users")
+	switch {
+	case path == "" && r.Method == http.MethodGet:
+		list, err := users.List()
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			writeJSON(w, map[string]string{"error": err.Error()})
+			return
+		}
+		out := make([]map[string]interface{}, 0, len(list))
+		for i := range list {
+			out = append(out, list[i].public())
+		}
+		writeJSON(w, map[string]interface{}{
+			"users":     out,
+			"count":     len(out),
+			"served_by": hostname,
+		})
+
+	case path == "" && r.Method == http.MethodPost:
+		var req struct {
+			Username string `json:"username"`
+			Password string `json:"password"`
+			Role     string `json:"role"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			writeJSON(w, map[string]string{"error": "invalid json"})
+			return
+		}
+		if req.Role == "" {
+			req.Role = roleUser
+		}
+		u, err := users.Create(req.Username, req.Password, req.Role, admin.Username+"@"+hostname)
+		if err != nil {
+			writeUserError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+		resp := u.public()
+		resp["served_by"] = hostname
+		writeJSON(w, resp)
+
+	case path == "/update" && r.Method == http.MethodPost:
+		var req struct {
+			Username string `json:"username"`
+			Password string `json:"password"`
+			Role     string `json:"role"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			writeJSON(w, map[string]string{"error": "invalid json"})
+			return
+		}
+		u, err := users.Update(req.Username, admin.Username+"@"+hostname, func(u *User) error {
+			if req.Role != "" {
+				if req.Role != roleAdmin && req.Role != roleUser {
+					return fmt.Errorf("%w: role must be %q or %q", ErrInvalidUser, roleAdmin, roleUser)
+				}
+				u.Role = req.Role
+			}
+			if req.Password != "" {
+				hash, err := users.hash(req.Password)
+				if err != nil {
+					return err
+				}
+				u.PasswordHash = hash
+			}
+			return nil
+		})
+		if err != nil {
+			writeUserError(w, err)
+			return
+		}
+		resp := u.public()
+		resp["served_by"] = hostname
+		writeJSON(w, resp)
+
+	case strings.HasPrefix(path, "/delete/") && r.Method == http.MethodPost:
+		username := strings.TrimPrefix(path, "/delete/")
+		if username == admin.Username {
+			w.WriteHeader(http.StatusBadRequest)
+			writeJSON(w, map[string]string{"error": "can't delete yourself"})
+			return
+		}
+		if err := users.Delete(username); err != nil {
+			writeUserError(w, err)
+			return
+		}
+		n, err := deleteUserSessions(username)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			writeJSON(w, map[string]string{"error": "user deleted, removing sessions: " + err.Error()})
+			return
+		}
+		writeJSON(w, map[string]interface{}{
+			"status":           "deleted",
+			"username":         username,
+			"sessions_deleted": n,
+			"served_by":        hostname,
+		})
+
+	default:
+		http.Error(w, "not found", http.StatusNotFound)
+	}
+}
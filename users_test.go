@@ -0,0 +1,100 @@
+package main
+
+import (
+	"errors"
+	"sync"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestUserStoreSeedAndAuth(t *testing.T) {
+	store, err := NewUserStore(t.TempDir(), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u, err := store.Authenticate("alice", "password123"); err != nil || u.Role != roleAdmin {
+		t.Fatalf("seeded admin: %+v, %v", u, err)
+	}
+	if _, err := store.Authenticate("alice", "password456"); !errors.Is(err, ErrBadPassword) {
+		t.Fatalf("wrong password: got %v", err)
+	}
+	if _, err := store.Authenticate("mallory", "password123"); !errors.Is(err, ErrBadPassword) {
+		t.Fatalf("unknown user: got %v", err)
+	}
+
+	if _, err := store.Create("carol", "short", roleUser, "test"); !errors.Is(err, ErrInvalidUser) {
+		t.Fatalf("short password: got %v", err)
+	}
+	if _, err := store.Create("../carol", "longenough", roleUser, "test"); !errors.Is(err, ErrInvalidUser) {
+		t.Fatalf("path in username: got %v", err)
+	}
+	if _, err := store.Create("bob", "longenough", roleUser, "test"); !errors.Is(err, ErrUserExists) {
+		t.Fatalf("duplicate user: got %v", err)
+	}
+
+	if _, err := store.SetPassword("bob", "new-password", "test"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Authenticate("bob", "password456"); err == nil {
+		t.Fatal("old password still works")
+	}
+	if _, err := store.Authenticate("bob", "new-password"); err != nil {
+		t.Fatalf("new password: %v", err)
+	}
+}
+
+// TestUserStoreConcurrentInstances drives two stores on one directory, standing
+// in for two instances on the share: registrations of the same name must have a
+// single winner and no update may be lost. in one process the exclusion comes
+// from lockPath's mutex; across hosts the same path takes the fcntl lock.
+func TestUserStoreConcurrentInstances(t *testing.T) {
+	dir := t.TempDir()
+	a, err := NewUserStore(dir, bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := NewUserStore(dir, bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if list, _ := b.List(); len(list) != len(seedUsers) {
+		t.Fatalf("second instance re-seeded: %d users", len(list))
+	}
+
+	const n = 20
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	wins := 0
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(store *UserStore) {
+			defer wg.Done()
+			if _, err := store.Create("dave", "password789", roleUser, "test"); err == nil {
+				mu.Lock()
+				wins++
+				mu.Unlock()
+			}
+		}([]*UserStore{a, b}[i%2])
+	}
+	wg.Wait()
+	if wins != 1 {
+		t.Fatalf("%d concurrent registrations succeeded, want 1", wins)
+	}
+
+	before, _ := a.Get("dave")
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(store *UserStore) {
+			defer wg.Done()
+			if _, err := store.Update("dave", "test", func(u *User) error { return nil }); err != nil {
+				t.Errorf("update: %v", err)
+			}
+		}([]*UserStore{a, b}[i%2])
+	}
+	wg.Wait()
+	after, _ := b.Get("dave")
+	if after.Version != before.Version+n {
+		t.Fatalf("version %d after %d updates from %d, lost %d", after.Version, n, before.Version, before.Version+n-after.Version)
+	}
+}